@@ -35,8 +35,10 @@ to quickly create a Cobra application.`,
 		ebiten.SetWindowTitle("Card game")
 
 		// Start the game loop
+		game := screens.NewCardGame(screenWidth / 2 / ui.TileSize, screenHeight / 2 / ui.TileSize)
+		defer game.Close()
 		prog := &ui.Program{
-			M: screens.NewCardGame(screenWidth / 2 / ui.TileSize, screenHeight / 2 / ui.TileSize),
+			M: game,
 			Width: screenWidth / 2,
 			Height: screenHeight / 2,
 		}
@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"maps"
+	"math/rand"
+	"slices"
+)
+
+type cloner struct {
+	game      *GameState
+	players   map[*Player]*Player
+	cards     map[*CardInstance]*CardInstance
+	abilities map[*AbilityInstance]*AbilityInstance
+}
+
+// Clone returns an independent deep copy of the game for simulations. The
+// copy has no event handlers or recording and players keep their deciders,
+// so a clone whose players answer directly can be run without goroutines.
+// Clone should not be called while the game is changing.
+func (g *GameState) Clone() *GameState {
+	c := &cloner{
+		game: &GameState{
+			Players:       []*Player{},
+			seed:          g.seed,
+			source:        newCountingSource(g.seed, g.source.draws),
+			stack:         Stack{cards: []*AbilityInstance{}},
+			currentEvent:  g.currentEvent,
+			eventHandlers: map[EventType][]EventHandler{},
+			currentId:     g.currentId,
+			timestamp:     g.timestamp,
+			damageRule:    g.damageRule,
+		},
+		players:   map[*Player]*Player{},
+		cards:     map[*CardInstance]*CardInstance{},
+		abilities: map[*AbilityInstance]*AbilityInstance{},
+	}
+	c.game.rng = rand.New(c.game.source)
+	for _, p := range g.Players {
+		c.players[p] = &Player{
+			Id:         p.Id,
+			game:       c.game,
+			nr:         p.nr,
+			life:       p.life,
+			essence:    append([]string{}, p.essence...),
+			turnsAfter: p.turnsAfter,
+			lost:       p.lost,
+			losing:     p.losing,
+			loss:       p.loss,
+			decklist:   p.decklist,
+			decider:    p.decider,
+			msgChan:    make(chan Msg, 1),
+		}
+		c.game.Players = append(c.game.Players, c.players[p])
+	}
+	for _, p := range g.Players {
+		np := c.players[p]
+		np.deck = Pile{Cards: c.cardList(p.deck.Cards)}
+		np.hand = Pile{Cards: c.cardList(p.hand.Cards)}
+		np.pile = Pile{Cards: c.cardList(p.pile.Cards)}
+		np.board = Board{Slots: c.cardList(p.board.Slots)}
+	}
+	for _, a := range g.stack.cards {
+		c.game.stack.Add(c.ability(a))
+	}
+	if g.resolving != nil {
+		c.game.resolving = c.ability(g.resolving)
+	}
+	if g.turn != nil {
+		t := &Turn{c.game, c.players[g.turn.player], nil, g.turn.turn, g.turn.sourcesPlayed}
+		if g.turn.phase != nil {
+			t.phase = &Phase{t, c.players[g.turn.phase.priority], g.turn.phase.phase}
+		}
+		c.game.turn = t
+	}
+	return c.game
+}
+
+func (c *cloner) card(card *CardInstance) *CardInstance {
+	if card == nil {
+		return nil
+	}
+	if nc, ok := c.cards[card]; ok {
+		return nc
+	}
+	nc := &CardInstance{
+		ID:         card.ID,
+		Card:       card.Card,
+		activated:  card.activated,
+		attacking:  card.attacking,
+		flipped:    card.flipped,
+		destroyed:  card.destroyed,
+		timestamp:  card.timestamp,
+		damage:     card.damage,
+		prevent:    card.prevent,
+		counters:   maps.Clone(card.counters),
+		zone:       card.zone,
+		index:      card.index,
+		Owner:      c.players[card.Owner],
+		Controller: c.players[card.Controller],
+		modifier:   append([]Mods{}, card.modifier...),
+	}
+	if card.stats != nil {
+		stats := *card.stats
+		nc.stats = &stats
+	}
+	c.cards[card] = nc
+	return nc
+}
+
+func (c *cloner) cardList(cards []*CardInstance) []*CardInstance {
+	list := make([]*CardInstance, len(cards))
+	for i, card := range cards {
+		list[i] = c.card(card)
+	}
+	return list
+}
+
+func (c *cloner) object(o any) any {
+	switch v := o.(type) {
+	case *Player:
+		return c.players[v]
+	case *CardInstance:
+		return c.card(v)
+	case *AbilityInstance:
+		return c.ability(v)
+	}
+	return o
+}
+
+func (c *cloner) objects(objects []any) []any {
+	if objects == nil {
+		return nil
+	}
+	list := make([]any, len(objects))
+	for i, o := range objects {
+		list[i] = c.object(o)
+	}
+	return list
+}
+
+func (c *cloner) ability(a *AbilityInstance) *AbilityInstance {
+	if na, ok := c.abilities[a]; ok {
+		return na
+	}
+	na := &AbilityInstance{
+		Source:     c.card(a.Source),
+		Controller: c.players[a.Controller],
+		Ability:    a.Ability,
+		Field:      a.Field,
+		X:          a.X,
+		Modes:      slices.Clone(a.Modes),
+		Event:      a.Event,
+		Copy:       a.Copy,
+		id:         a.id,
+	}
+	c.abilities[a] = na
+	na.This = c.objects(a.This)
+	na.Sacrificed = c.objects(a.Sacrificed)
+	na.Targeting = c.objects(a.Targeting)
+	if a.Effects != nil {
+		na.Effects = make([]EffectInstance, len(a.Effects))
+	}
+	for i, e := range a.Effects {
+		na.Effects[i] = EffectInstance{
+			Ability:  na,
+			Subjects: c.objects(e.Subjects),
+			Effect:   e.Effect,
+			Match:    e.Match,
+			Zone:     e.Zone,
+			Optional: e.Optional,
+			guards:   e.guards,
+			matches:  c.objects(e.matches),
+			zones:    append([]Zone(nil), e.zones...),
+		}
+	}
+	return na
+}
@@ -0,0 +1,300 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Decider makes the decisions for a player. The engine calls it on the
+// game's goroutine and waits for the answer. Choices are answered with an
+// index into the given choices, SkipCode passes and an error stops the game.
+type Decider interface {
+	// ChooseCard picks a card to play or activate.
+	ChooseCard(p *Player, choices []any) (int, error)
+	// ChooseAbility picks one of the activatable abilities of a card.
+	ChooseAbility(p *Player, choices []any) (int, error)
+	// ChooseField picks a free board slot.
+	ChooseField(p *Player, choices []any) (int, error)
+	// ChooseTarget picks a target for an ability.
+	ChooseTarget(p *Player, choices []any) (int, error)
+	// ChooseDiscard picks n cards to discard.
+	ChooseDiscard(p *Player, n int, choices []any) ([]int, error)
+	// ChooseSacrifice picks n of the player's objects to sacrifice to pay a
+	// cost.
+	ChooseSacrifice(p *Player, n int, choices []any) ([]int, error)
+	// ChooseSource returns 1 to play a card as a source and 0 to cast it.
+	ChooseSource(p *Player) (int, error)
+	// ChooseBlock declares blockers, the choices are Block pairs of an
+	// attacking unit and a unit that can block it and the answer lists the
+	// chosen blocks. Each unit blocks and is blocked at most once.
+	ChooseBlock(p *Player, choices []any) ([]int, error)
+	// Look shows the player cards only they can see and returns once they
+	// are done looking.
+	Look(p *Player, cards []any) error
+	// ChooseOrder puts the cards in order, the answer lists the indexes of
+	// the cards from first to last.
+	ChooseOrder(p *Player, cards []any) ([]int, error)
+	// Confirm decides whether to do an optional effect of the card.
+	Confirm(p *Player, card *CardInstance) (bool, error)
+	// ConfirmPay decides whether to pay the costs to stop an effect of the
+	// card, like "unless its controller spends {1}".
+	ConfirmPay(p *Player, card *CardInstance, costs []CostType) (bool, error)
+	// ChooseModes picks n of the modes of a modal ability.
+	ChooseModes(p *Player, n int, modes []any) ([]int, error)
+	// ChooseX picks the value of X in a cost, the choices are the values the
+	// player can pay.
+	ChooseX(p *Player, choices []any) (int, error)
+	// ChooseEssence picks the essence to spend on a cost symbol when there
+	// is more than one way to pay.
+	ChooseEssence(p *Player, choices []any) (int, error)
+}
+
+func (p *Player) SetDecider(d Decider) {
+	p.decider = d
+}
+
+// ChannelDecider answers prompts with the messages sent to the player with
+// Send, frontends listen for the prompt events and send the choice back.
+type ChannelDecider struct{}
+
+func (ChannelDecider) ChooseCard(p *Player, choices []any) (int, error) {
+	return p.receive()
+}
+
+func (ChannelDecider) ChooseAbility(p *Player, choices []any) (int, error) {
+	return p.receive()
+}
+
+func (ChannelDecider) ChooseField(p *Player, choices []any) (int, error) {
+	return p.receive()
+}
+
+func (ChannelDecider) ChooseTarget(p *Player, choices []any) (int, error) {
+	return p.receive()
+}
+
+func (ChannelDecider) ChooseDiscard(p *Player, n int, choices []any) ([]int, error) {
+	return p.receiveAll()
+}
+
+func (ChannelDecider) ChooseSacrifice(p *Player, n int, choices []any) ([]int, error) {
+	return p.receiveAll()
+}
+
+func (ChannelDecider) ChooseSource(p *Player) (int, error) {
+	return p.receive()
+}
+
+func (ChannelDecider) ChooseBlock(p *Player, choices []any) ([]int, error) {
+	return p.receiveAll()
+}
+
+func (ChannelDecider) Look(p *Player, cards []any) error {
+	_, err := p.receive()
+	return err
+}
+
+func (ChannelDecider) ChooseOrder(p *Player, cards []any) ([]int, error) {
+	return p.receiveAll()
+}
+
+func (ChannelDecider) Confirm(p *Player, card *CardInstance) (bool, error) {
+	choice, err := p.receive()
+	return choice == 0, err
+}
+
+func (ChannelDecider) ConfirmPay(p *Player, card *CardInstance, costs []CostType) (bool, error) {
+	choice, err := p.receive()
+	return choice == 0, err
+}
+
+func (ChannelDecider) ChooseModes(p *Player, n int, modes []any) ([]int, error) {
+	return p.receiveAll()
+}
+
+func (ChannelDecider) ChooseX(p *Player, choices []any) (int, error) {
+	return p.receive()
+}
+
+func (ChannelDecider) ChooseEssence(p *Player, choices []any) (int, error) {
+	return p.receive()
+}
+
+// drain drops an answer that is still pending.
+func (p *Player) drain() {
+	select {
+	case <-p.msgChan:
+	default:
+	}
+}
+
+func (p *Player) receiveAll() ([]int, error) {
+	select {
+	case msg := <-p.msgChan:
+		return msg.Selected, msg.Err
+	case <-p.Context().Done():
+		return nil, p.Context().Err()
+	}
+}
+
+func (p *Player) receive() (int, error) {
+	var msg Msg
+	select {
+	case msg = <-p.msgChan:
+	case <-p.Context().Done():
+		return ErrorCode, p.Context().Err()
+	}
+	if msg.Err != nil {
+		return ErrorCode, msg.Err
+	}
+	if len(msg.Selected) == 0 {
+		return SkipCode, nil
+	}
+	return msg.Selected[0], nil
+}
+
+// decide asks the player's decider to answer a prompt within the prompt
+// timeout.
+func (p *Player) decide(cmd string, num int, choices []any) Msg {
+	ctx := p.game.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cancel := context.CancelFunc(func() {})
+	if p.game.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, p.game.timeout)
+	}
+	p.ctx = ctx
+	defer func() {
+		cancel()
+		p.ctx = nil
+	}()
+
+	var choice int
+	var selected []int
+	var err error
+	switch cmd {
+	case "card":
+		choice, err = p.decider.ChooseCard(p, choices)
+	case "ability":
+		choice, err = p.decider.ChooseAbility(p, choices)
+	case "field":
+		choice, err = p.decider.ChooseField(p, choices)
+	case "target":
+		choice, err = p.decider.ChooseTarget(p, choices)
+	case "discard":
+		selected, err = p.decider.ChooseDiscard(p, num, choices)
+	case "sacrifice":
+		selected, err = p.decider.ChooseSacrifice(p, num, choices)
+	case "source":
+		choice, err = p.decider.ChooseSource(p)
+	case "block":
+		selected, err = p.decider.ChooseBlock(p, choices)
+	case "look":
+		choice, err = SkipCode, p.decider.Look(p, choices)
+	case "order":
+		selected, err = p.decider.ChooseOrder(p, choices)
+	case "mode":
+		selected, err = p.decider.ChooseModes(p, num, choices)
+	case "x":
+		choice, err = p.decider.ChooseX(p, choices)
+	case "pay":
+		choice, err = p.decider.ChooseEssence(p, choices)
+	case "confirm":
+		var ok bool
+		choice = SkipCode
+		if ok, err = p.decider.Confirm(p, choices[0].(*CardInstance)); ok {
+			choice = 0
+		}
+	case "unless":
+		costs := []CostType{}
+		for _, c := range choices[1:] {
+			costs = append(costs, c.(CostType))
+		}
+		var ok bool
+		choice = SkipCode
+		if ok, err = p.decider.ConfirmPay(p, choices[0].(*CardInstance), costs); ok {
+			choice = 0
+		}
+	default:
+		choice = SkipCode
+	}
+	if p.game.ctx != nil && p.game.ctx.Err() != nil {
+		return Msg{Selected: []int{ErrorCode}, Err: p.game.ctx.Err()}
+	}
+	if ctx.Err() != nil {
+		return p.timedOut(cmd, num, choices)
+	}
+	if selected == nil {
+		selected = []int{choice}
+	}
+	return Msg{Selected: selected, Err: err}
+}
+
+type TimeoutPolicy int8
+
+const (
+	// TimeoutSkip passes the prompt, discards take the first cards.
+	TimeoutSkip TimeoutPolicy = iota
+	// TimeoutRandom makes a random legal choice.
+	TimeoutRandom
+	// TimeoutForfeit makes the player lose the game.
+	TimeoutForfeit
+)
+
+var ErrPromptTimeout = errors.New("prompt timed out")
+
+// SetPromptTimeout limits how long a player may take to answer a prompt, a
+// zero duration waits forever. When the time is up the policy decides the
+// answer, deciders can watch Player.Context to stop waiting.
+func (g *GameState) SetPromptTimeout(d time.Duration, policy TimeoutPolicy) {
+	g.timeout = d
+	g.onTimeout = policy
+	if g.timeoutRng == nil {
+		// Separate from the game rng so timeouts don't change the shuffles
+		g.timeoutRng = rand.New(rand.NewSource(g.seed))
+	}
+}
+
+// Context returns the context of the prompt the player is answering, it is
+// done when the prompt times out or the game is cancelled.
+func (p *Player) Context() context.Context {
+	if p.ctx != nil {
+		return p.ctx
+	}
+	if p.game.ctx != nil {
+		return p.game.ctx
+	}
+	return context.Background()
+}
+
+// timedOut picks the answer to a prompt that was not answered in time.
+func (p *Player) timedOut(cmd string, num int, choices []any) Msg {
+	switch p.game.onTimeout {
+	case TimeoutRandom:
+		if cmd == "discard" || cmd == "sacrifice" || cmd == "order" || cmd == "mode" {
+			return Msg{Selected: p.game.timeoutRng.Perm(len(choices))[:min(num, len(choices))]}
+		}
+		if cmd == "source" {
+			return Msg{Selected: []int{p.game.timeoutRng.Intn(2)}}
+		}
+		if cmd == "confirm" || cmd == "unless" {
+			return Msg{Selected: []int{[]int{0, SkipCode}[p.game.timeoutRng.Intn(2)]}}
+		}
+		if len(choices) > 0 {
+			return Msg{Selected: []int{p.game.timeoutRng.Intn(len(choices))}}
+		}
+	case TimeoutForfeit:
+		return Msg{Selected: []int{ErrorCode}, Err: ErrPromptTimeout}
+	}
+	if cmd == "discard" || cmd == "sacrifice" || cmd == "order" || cmd == "mode" {
+		selected := []int{}
+		for i := range min(num, len(choices)) {
+			selected = append(selected, i)
+		}
+		return Msg{Selected: selected}
+	}
+	return Msg{Selected: []int{SkipCode}}
+}
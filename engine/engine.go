@@ -1,10 +1,20 @@
 package engine
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
 	"iter"
+	"maps"
+	"math"
+	"math/bits"
 	"math/rand"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/alecthomas/participle/v2"
 	"github.com/alecthomas/participle/v2/lexer"
@@ -34,6 +44,7 @@ const (
 	EventOnLeaveBoard
 	EventOnDestroy
 	EventOnSacrifice
+	EventOnCountered
 	EventOnTarget
 	EventOnActivate
 	EventOnDeactivate
@@ -56,6 +67,15 @@ const (
 	EventPromptTarget
 	EventPromptSource
 	EventPromptDiscard
+	EventPromptBlock
+	EventPromptLook
+	EventPromptOrder
+	EventPromptConfirm
+	EventPromptMode
+	EventPromptX
+	EventPromptPay
+	EventPromptUnless
+	EventPromptSacrifice
 
 	ZoneAny Zone = iota
 	ZoneDeck
@@ -118,6 +138,8 @@ func (e EventType) String() string {
 		return "destroy"
 	case EventOnSacrifice:
 		return "sacrifice"
+	case EventOnCountered:
+		return "countered"
 	case EventOnTarget:
 		return "target"
 	case EventOnActivate:
@@ -162,6 +184,24 @@ func (e EventType) String() string {
 		return "prompt-source"
 	case EventPromptDiscard:
 		return "prompt-discard"
+	case EventPromptBlock:
+		return "prompt-block"
+	case EventPromptLook:
+		return "prompt-look"
+	case EventPromptOrder:
+		return "prompt-order"
+	case EventPromptConfirm:
+		return "prompt-confirm"
+	case EventPromptMode:
+		return "prompt-mode"
+	case EventPromptX:
+		return "prompt-x"
+	case EventPromptPay:
+		return "prompt-pay"
+	case EventPromptUnless:
+		return "prompt-unless"
+	case EventPromptSacrifice:
+		return "prompt-sacrifice"
 	}
 	return "unknown"
 }
@@ -227,9 +267,9 @@ func (p *Pile) Pop() *CardInstance {
 	return card
 }
 
-func (p *Pile) Shuffle() {
+func (p *Pile) Shuffle(rng *rand.Rand) {
 	for i := range p.Cards {
-		j := rand.Intn(i + 1)
+		j := rng.Intn(i + 1)
 		p.Cards[i], p.Cards[j] = p.Cards[j], p.Cards[i]
 	}
 }
@@ -253,13 +293,28 @@ type Player struct {
 	board      Board
 	essence    []string
 	turnsAfter int
+	decklist   []*Card
+	decider    Decider
+	ctx        context.Context
 	msgChan    chan Msg
+	// waiting is set while a prompt of the player waits for an answer,
+	// messages sent at other times are late answers and are dropped.
+	waiting atomic.Bool
+	lost    bool
+	losing  bool
+	loss    EndReason
 }
 
 func (p *Player) GetId() int { return p.Id }
 
 func (p *Player) Run() bool {
 	for {
+		if p.game.checkState() {
+			return false
+		}
+		if p.lost {
+			return true
+		}
 		selected := []int{}
 		choices := p.GetPlayableCards()
 		if !p.prompt("card", 1, choices, &selected) || selected[0] < 0 || selected[0] >= len(choices) {
@@ -270,7 +325,7 @@ func (p *Player) Run() bool {
 			activatable := []any{}
 			activatableAbilities := []*Activated{}
 			for i, a := range card.GetActivatedAbilities() {
-				if a.CanDo(card) {
+				if card.CanActivate(a) {
 					activatable = append(activatable, fmt.Sprintf("%d.%d", card.GetId(), i))
 					activatableAbilities = append(activatableAbilities, a)
 				}
@@ -301,27 +356,40 @@ func (p *Player) Run() bool {
 				}
 				flipped = selected[0] == 1
 			}
-			fields := p.freeFields(card)
-			selected = []int{}
-			if !p.prompt("field", 1, fields, &selected) || selected[0] < 0 {
-				if selected[0] == SkipCode {
-					continue
+			field := -1
+			if flipped || !card.IsSpell() {
+				// Spells don't need a place on the board
+				fields := p.freeFields(card)
+				selected = []int{}
+				if !p.prompt("field", 1, fields, &selected) || selected[0] < 0 {
+					if selected[0] == SkipCode {
+						continue
+					}
+					return false
 				}
-				return false
+				field = fields[selected[0]].(int)
 			}
 			card.flipped = flipped
 			if flipped {
 				p.game.turn.sourcesPlayed += 1
-				card.Play(fields[selected[0]].(int))
+				card.Play(field)
 			} else {
-				p.game.Play(card.Cast(fields[selected[0]].(int)))
+				p.game.Play(card.Cast(field))
 			}
 		}
 	}
 }
 
+// Send answers the prompt the player is waiting on. Answers that arrive when
+// no prompt is waiting, or while an answer is already pending, are dropped.
 func (p *Player) Send(msg Msg) {
-	p.msgChan <- msg
+	if !p.waiting.Load() {
+		return
+	}
+	select {
+	case p.msgChan <- msg:
+	default:
+	}
 }
 
 func (p *Player) Emit(event EventType, args ...any) {
@@ -334,13 +402,43 @@ func (p *Player) prompt(
 	choices []any,
 	selected *[]int,
 ) bool {
+	if p.game.result != nil {
+		// The game is over, nobody has to decide anything anymore
+		*selected = []int{ErrorCode}
+		return false
+	}
+	if p.game.ctx != nil && p.game.ctx.Err() != nil {
+		p.game.stop(p.game.ctx.Err())
+		*selected = []int{ErrorCode}
+		return false
+	}
+
+	p.drain()
+	p.waiting.Store(true)
 	// Emit appropriate event based on command type
-	go p.Emit(p.getPromptEventType(cmd), append([]any{num}, choices...)...)
+	p.Emit(p.getPromptEventType(cmd), append([]any{num}, choices...)...)
 
 	// Wait for response
-	response := <-p.msgChan
+	response := p.decide(cmd, num, choices)
+	p.waiting.Store(false)
+	// An answer sent while the prompt timed out is not for the next prompt
+	p.drain()
+	if p.game.recording != nil {
+		p.game.recording.record(p.game, p, response)
+	}
 
+	if errors.Is(response.Err, ErrConcede) || errors.Is(response.Err, ErrPromptTimeout) {
+		if errors.Is(response.Err, ErrConcede) {
+			p.lose(EndConcede)
+		} else {
+			p.lose(EndForfeit)
+		}
+		p.game.checkLosses()
+		*selected = []int{SkipCode}
+		return false
+	}
 	if response.Err != nil {
+		p.game.stop(response.Err)
 		*selected = []int{ErrorCode}
 		return false
 	}
@@ -363,6 +461,24 @@ func (p *Player) getPromptEventType(cmd string) EventType {
 		return EventPromptSource
 	case "discard":
 		return EventPromptDiscard
+	case "block":
+		return EventPromptBlock
+	case "look":
+		return EventPromptLook
+	case "order":
+		return EventPromptOrder
+	case "confirm":
+		return EventPromptConfirm
+	case "unless":
+		return EventPromptUnless
+	case "mode":
+		return EventPromptMode
+	case "x":
+		return EventPromptX
+	case "pay":
+		return EventPromptPay
+	case "sacrifice":
+		return EventPromptSacrifice
 	default:
 		return NoEvent
 	}
@@ -376,8 +492,22 @@ func (p *Player) GainLife(n int) {
 func (p *Player) LoseLife(n int) {
 	p.life -= n
 	p.Emit(EventOnLoseLife, n)
-	if p.life <= 0 {
-		p.Emit(EventOnLose)
+}
+
+// Concede makes the player lose at the next state check. It must be called
+// on the game's goroutine, frontends answer a prompt with ErrConcede instead.
+func (p *Player) Concede() {
+	p.lose(EndConcede)
+}
+
+func (p *Player) Lost() bool {
+	return p.lost
+}
+
+func (p *Player) lose(reason EndReason) {
+	if !p.losing {
+		p.losing = true
+		p.loss = reason
 	}
 }
 
@@ -388,18 +518,18 @@ func (p *Player) Draw(n int) {
 			p.Place(card, ZoneHand, 0)
 			p.Emit(EventOnDraw, card)
 		} else {
-			p.Emit(EventOnLose)
+			// Drawing from an empty deck loses at the next state check
+			p.lose(EndDeck)
 		}
-		// TODO: handle empty deck
 	}
 }
 
 func (p *Player) Shuffle(zone Zone) {
 	switch zone {
 	case ZoneDeck:
-		p.deck.Shuffle()
+		p.deck.Shuffle(p.game.rng)
 	case ZonePile:
-		p.pile.Shuffle()
+		p.pile.Shuffle(p.game.rng)
 	default:
 		panic("Invalid zone")
 	}
@@ -417,12 +547,20 @@ func (p *Player) Place(card *CardInstance, zone Zone, index int) {
 	case ZoneBoard:
 		p.board.Insert(card, index)
 		card.Controller = p
+		p.game.timestamp += 1
+		card.timestamp = p.game.timestamp
 		p.Emit(EventOnEnterBoard, card, index)
 	default:
 		panic("Invalid zone")
 	}
 	card.zone = zone
 	card.index = index
+	card.attacking = false
+	card.destroyed = false
+	card.damage = 0
+	card.prevent = 0
+	card.counters = nil
+	card.modifier = []Mods{}
 }
 
 func (p *Player) Remove(card *CardInstance) {
@@ -436,6 +574,10 @@ func (p *Player) Remove(card *CardInstance) {
 		p.Emit(EventOnLeaveBoard, card)
 	case ZoneDeck:
 		p.deck.Remove(card)
+	case ZoneStack:
+		// Cards on the stack are only held by their ability instance.
+	case ZoneAny:
+		// Tokens that left the board are in no zone anymore.
 	default:
 		panic("Invalid zone")
 	}
@@ -480,9 +622,9 @@ func (p *Player) Query(a *AbilityInstance, obj Match, zone *ZoneMatch) []any {
 		}
 	}
 	if p.matchField(a, ZoneStack, zone) {
-		for _, card := range p.game.stack.cards {
-			if card.Source.Owner == p && (obj == nil || obj.Match(a, card.Source)) {
-				found = append(found, card.Source)
+		for _, item := range p.game.stack.cards {
+			if item.Controller == p && (obj == nil || obj.Match(a, item)) {
+				found = append(found, item)
 			}
 		}
 	}
@@ -490,87 +632,303 @@ func (p *Player) Query(a *AbilityInstance, obj Match, zone *ZoneMatch) []any {
 }
 
 func (p *Player) CanPay(card *CardInstance, costs []AbilityCost) bool {
-	// TODO: add potential essence from essence sources
-	// TODO: add extra costs
-	pool := make([]string, len(p.essence))
-	copy(pool, p.essence)
 	for _, ct := range costs {
-		if ct.Cost != nil {
-			if ct.Cost.Activate {
-				if card.activated {
-					return false
-				}
-			} else if ct.Cost.Deactivate {
-				if !card.activated {
-					return false
-				}
-			} else if ct.Cost.Color != "" {
-				found := false
-				for i, c := range pool {
-					if c == ct.Cost.Color {
-						pool = append(pool[:i], pool[i+1:]...)
-						found = true
-						break
-					}
-				}
-				if !found {
-					return false
-				}
-			}
+		if ct.Action != nil && !p.canPayAction(card, *ct.Action) {
+			return false
 		}
 	}
-	for _, ct := range costs {
-		if ct.Cost != nil &&
-			!ct.Cost.Activate &&
-			!ct.Cost.Deactivate &&
-			ct.Cost.Color == "" {
-			num := ct.Cost.Number.Number
-			if len(pool) < num {
+	return p.canPayEssence(card, costs, 0)
+}
+
+// canPayAction reports whether the player has what the action cost needs:
+// something to sacrifice, cards to discard or enough life to spend.
+func (p *Player) canPayAction(card *CardInstance, action Effect) bool {
+	cost := NewAbilityInstance(p, card, nil)
+	action.Do(&EffectInstance{Ability: cost, Effect: action})
+	for _, e := range cost.Effects {
+		switch ef := e.Effect.(type) {
+		case Sacrifice:
+			if len(p.Query(cost, ef.Objects, e.Zone)) == 0 {
 				return false
 			}
-			for i := 0; i < num; i++ {
-				found := false
-				for j, c := range pool {
-					if c == "u" {
-						pool = append(pool[:j], pool[j+1:]...)
-						found = true
-						break
-					}
-				}
-				if !found {
-					pool = append(pool[:0], pool[1:]...)
+		case Discard:
+			cards := p.hand.Cards
+			if ef.Value != nil {
+				cards = nil
+				for _, c := range p.Query(cost, ef.Value, e.Zone) {
+					cards = append(cards, c.(*CardInstance))
 				}
 			}
+			if len(cards) < ef.Number.Value(cost) {
+				return false
+			}
+		case PayLife:
+			if p.life < ef.Value.Value(cost) {
+				return false
+			}
 		}
 	}
 	return true
 }
 
-func (p *Player) Pay(card *CardInstance, costs []AbilityCost) {
-	// TODO: pay action costs
+// canPayEssence reports whether the card can be activated or deactivated as
+// the costs need and the pool, with the essence the player's sources can
+// add, has essence for every symbol with X as x.
+func (p *Player) canPayEssence(card *CardInstance, costs []AbilityCost, x int) bool {
+	for _, ct := range costs {
+		if ct.Cost != nil && (ct.Cost.Activate && card.activated || ct.Cost.Deactivate && !card.activated) {
+			return false
+		}
+	}
+	pool := slices.Clone(p.essence)
+	for _, s := range p.essenceSources(card) {
+		pool = append(pool, s.essence...)
+	}
+	return payable(pool, essenceCosts(costs, x))
+}
+
+// essenceSource is an ability that adds essence by only activating or
+// deactivating its card, like the essence ability of a source.
+type essenceSource struct {
+	card    *CardInstance
+	ability *Activated
+	essence []string
+}
+
+// essenceSources returns the essence abilities the player can use to pay for
+// the costs of card, one for every card other than card itself.
+func (p *Player) essenceSources(card *CardInstance) []essenceSource {
+	sources := []essenceSource{}
+	for _, c := range p.board.Slots {
+		if c == nil || c == card {
+			continue
+		}
+		for _, a := range c.GetActivatedAbilities() {
+			if essence, ok := a.essence(c); ok {
+				sources = append(sources, essenceSource{c, a, essence})
+				break
+			}
+		}
+	}
+	return sources
+}
+
+// planSources returns the fewest sources to activate so the pool can pay for
+// the symbols, none if the pool can already pay.
+func (p *Player) planSources(card *CardInstance, symbols [][]string) []essenceSource {
+	sources := p.essenceSources(card)
+	best := -1
+	for plan := 0; plan < 1<<len(sources); plan++ {
+		if best >= 0 && bits.OnesCount(uint(plan)) >= bits.OnesCount(uint(best)) {
+			continue
+		}
+		pool := slices.Clone(p.essence)
+		for i, s := range sources {
+			if plan&(1<<i) != 0 {
+				pool = append(pool, s.essence...)
+			}
+		}
+		if payable(pool, symbols) {
+			best = plan
+		}
+	}
+	planned := []essenceSource{}
+	for i, s := range sources {
+		if best > 0 && best&(1<<i) != 0 {
+			planned = append(planned, s)
+		}
+	}
+	return planned
+}
+
+// essenceCosts lists the essence symbols of the costs with X as x. Every
+// symbol is the essence that can pay for it, nil if any essence can. Symbols
+// that need a color come first.
+func essenceCosts(costs []AbilityCost, x int) [][]string {
+	symbols := [][]string{}
+	for _, ct := range costs {
+		if ct.Cost == nil || ct.Cost.Activate || ct.Cost.Deactivate {
+			continue
+		}
+		if colors := ct.Cost.Colors(); colors != nil {
+			symbols = append(symbols, colors)
+			continue
+		}
+		n := ct.Cost.Number.Number
+		if ct.Cost.Number.X {
+			n = x
+		} else if ct.Cost.Color == "u" {
+			n = 1
+		}
+		for range n {
+			symbols = append(symbols, nil)
+		}
+	}
+	// Single colors first to cut the search short, any essence last
+	rank := func(s []string) int {
+		if s == nil {
+			return math.MaxInt
+		}
+		return len(s)
+	}
+	slices.SortStableFunc(symbols, func(a, b []string) int {
+		return cmp.Compare(rank(a), rank(b))
+	})
+	return symbols
+}
+
+// payable reports whether the pool can pay for the symbols, searching every
+// way to pay for the hybrid symbols.
+func payable(pool []string, symbols [][]string) bool {
+	if len(symbols) == 0 {
+		return true
+	} else if symbols[0] == nil {
+		// Only symbols any essence can pay for are left
+		return len(pool) >= len(symbols)
+	}
+	for i, e := range pool {
+		// Spending the same essence from another place changes nothing
+		if !slices.Contains(symbols[0], e) || slices.Index(pool, e) != i {
+			continue
+		}
+		if payable(slices.Delete(slices.Clone(pool), i, i+1), symbols[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxX returns the largest X the player can pay for the costs.
+func (p *Player) MaxX(card *CardInstance, costs []AbilityCost) int {
+	if !slices.ContainsFunc(costs, func(ct AbilityCost) bool { return ct.Cost != nil && ct.Cost.Number.X }) {
+		return 0
+	}
+	// X is bounded by the essence in the pool and what the sources can add
+	total := len(p.essence)
+	for _, s := range p.essenceSources(card) {
+		total += len(s.essence)
+	}
+	x := 0
+	for x < total && p.canPayEssence(card, costs, x+1) {
+		x++
+	}
+	return x
+}
+
+// chooseX asks the player for the value of X in the costs, from 0 up to the
+// most they can pay. Costs without X need no choice.
+func (p *Player) chooseX(card *CardInstance, costs []AbilityCost) int {
+	if !slices.ContainsFunc(costs, func(ct AbilityCost) bool { return ct.Cost != nil && ct.Cost.Number.X }) {
+		return 0
+	}
+	choices := []any{}
+	for x := range p.MaxX(card, costs) + 1 {
+		choices = append(choices, x)
+	}
+	selected := []int{}
+	if !p.prompt("x", 1, choices, &selected) || selected[0] < 0 || selected[0] >= len(choices) {
+		return 0
+	}
+	return selected[0]
+}
+
+// Pay pays the costs of the ability and returns the chosen value of X.
+// Objects sacrificed to pay are added to the sacrificed of the ability.
+func (p *Player) Pay(a *AbilityInstance, costs []AbilityCost) int {
 	// TODO: add choice of essence sources
-	// TODO: pay X
+	card := a.Source
+	x := p.chooseX(card, costs)
 	for _, cost := range costs {
 		if cost.Cost != nil {
 			if cost.Cost.Activate {
 				card.Activate()
 			} else if cost.Cost.Deactivate {
 				card.Deactivate()
-			} else {
-				p.RemoveEssence(cost.Cost.Color)
 			}
 		}
 	}
+	symbols := essenceCosts(costs, x)
+	for _, s := range p.planSources(card, symbols) {
+		s.ability.Do(p, s.card).Resolve()
+	}
+	p.payEssence(symbols)
 	for _, ct := range costs {
-		if ct.Cost != nil &&
-			!ct.Cost.Activate &&
-			!ct.Cost.Deactivate &&
-			ct.Cost.Color == "" {
-			for i := 0; i < ct.Cost.Number.Number; i++ {
-				p.RemoveEssence("u")
+		if ct.Action != nil {
+			p.payAction(a, *ct.Action)
+		}
+	}
+	return x
+}
+
+// payEssence removes essence from the pool for every symbol. When there is
+// more than one way to pay for a symbol the player chooses the essence.
+func (p *Player) payEssence(symbols [][]string) {
+	// Spending the whole pool leaves nothing to choose
+	all := len(p.essence) == len(symbols)
+	for i, s := range symbols {
+		options := []any{}
+		for j, e := range p.essence {
+			if (s == nil || slices.Contains(s, e)) && slices.Index(p.essence, e) == j &&
+				payable(slices.Delete(slices.Clone(p.essence), j, j+1), symbols[i+1:]) {
+				options = append(options, e)
+			}
+		}
+		if len(options) == 0 {
+			return
+		}
+		choice := 0
+		if len(options) > 1 && !all {
+			selected := []int{}
+			if p.prompt("pay", 1, options, &selected) && selected[0] >= 0 && selected[0] < len(options) {
+				choice = selected[0]
+			}
+		}
+		p.RemoveEssence(options[choice].(string))
+	}
+}
+
+// payAction does an action cost right away. Costs are paid with the player's
+// own objects, for "a unit" the player chooses which one.
+func (p *Player) payAction(a *AbilityInstance, action Effect) {
+	cost := NewAbilityInstance(p, a.Source, a.Ability)
+	action.Do(&EffectInstance{Ability: cost, Effect: action})
+	for i := range cost.Effects {
+		e := &cost.Effects[i]
+		if e.Match != nil {
+			e.matches = p.Query(cost, e.Match, e.Zone)
+			if _, ok := e.Effect.(Sacrifice); ok {
+				if n := e.Match.NrTargets(cost); n > 0 {
+					e.matches = p.chooseSacrifice(e.matches, n)
+				}
 			}
 		}
+		e.Effect.Resolve(e)
+	}
+	a.Sacrificed = append(a.Sacrificed, cost.Sacrificed...)
+}
+
+// chooseSacrifice asks the player which n of the objects to sacrifice. A cost
+// has to be paid, so when fewer are chosen the first other objects make up
+// the rest.
+func (p *Player) chooseSacrifice(objects []any, n int) []any {
+	selected := []int{}
+	p.prompt("sacrifice", n, objects, &selected)
+	chosen := []int{}
+	for _, i := range selected {
+		if i >= 0 && i < len(objects) && !slices.Contains(chosen, i) && len(chosen) < n {
+			chosen = append(chosen, i)
+		}
+	}
+	for i := 0; i < len(objects) && len(chosen) < n; i++ {
+		if !slices.Contains(chosen, i) {
+			chosen = append(chosen, i)
+		}
+	}
+	sacrificed := []any{}
+	for _, i := range chosen {
+		sacrificed = append(sacrificed, objects[i])
 	}
+	return sacrificed
 }
 
 func (p *Player) AddEssence(t string) {
@@ -647,6 +1005,132 @@ func (p *Player) matchField(ability *AbilityInstance, place Zone, zone *ZoneMatc
 	return zone.Match(ability, place, p)
 }
 
+// Blockers returns the units that can block attacker: activated units in the
+// opposite and adjacent lanes. Only units with fly can block units with fly.
+func (p *Player) Blockers(attacker *CardInstance) []any {
+	blockers := []any{}
+	for i := attacker.index - 1; i <= attacker.index+1; i++ {
+		if i < 0 || i >= len(p.board.Slots) {
+			continue
+		}
+		card := p.board.Slots[i]
+		if card == nil || !card.activated || !card.HasType("unit") {
+			continue
+		}
+		if attacker.HasKeyword("fly") && !card.HasKeyword("fly") {
+			continue
+		}
+		blockers = append(blockers, card)
+	}
+	return blockers
+}
+
+// Block pairs a unit that can block with the attacking unit it would block.
+type Block struct {
+	Attacker *CardInstance
+	Blocker  *CardInstance
+}
+
+// block lets the player declare blockers for all attacking units at once, the
+// choices are the possible blocks. Every unit blocks and is blocked at most
+// once, it returns the blocker of each blocked attacker.
+func (p *Player) block(attackers []*CardInstance) map[*CardInstance]*CardInstance {
+	choices := []any{}
+	for _, attacker := range attackers {
+		for _, blocker := range p.Blockers(attacker) {
+			choices = append(choices, Block{attacker, blocker.(*CardInstance)})
+		}
+	}
+	blocks := map[*CardInstance]*CardInstance{}
+	if len(choices) == 0 {
+		return blocks
+	}
+	selected := []int{}
+	if !p.prompt("block", len(attackers), choices, &selected) {
+		return blocks
+	}
+	blocking := map[*CardInstance]bool{}
+	for _, i := range selected {
+		if i < 0 || i >= len(choices) {
+			continue
+		}
+		b := choices[i].(Block)
+		if _, ok := blocks[b.Attacker]; ok || blocking[b.Blocker] {
+			continue
+		}
+		blocks[b.Attacker] = b.Blocker
+		blocking[b.Blocker] = true
+	}
+	for _, attacker := range attackers {
+		if blocker, ok := blocks[attacker]; ok {
+			p.Emit(EventOnBlock, blocker, attacker)
+		}
+	}
+	return blocks
+}
+
+// confirm asks the player whether to do an optional effect of the card, the
+// card is the only choice and passing declines.
+func (p *Player) confirm(card *CardInstance) bool {
+	selected := []int{}
+	return p.prompt("confirm", 1, []any{card}, &selected) && selected[0] == 0
+}
+
+// payUnless asks the player whether to pay the costs to stop an effect of the
+// card. The card is the first choice followed by the costs, choosing the card
+// pays and passing declines.
+func (p *Player) payUnless(card *CardInstance, costs []CostType) bool {
+	choices := []any{card}
+	for _, c := range costs {
+		choices = append(choices, c)
+	}
+	selected := []int{}
+	return p.prompt("unless", 1, choices, &selected) && selected[0] == 0
+}
+
+// chooseModes asks the player to choose n of the modes and returns the chosen
+// ones in the order they are written. When fewer valid modes are chosen the
+// first modes that were not chosen make up the rest.
+func (p *Player) chooseModes(modes []Mode, n int) []int {
+	choices := []any{}
+	for _, m := range modes {
+		choices = append(choices, m)
+	}
+	selected := []int{}
+	p.prompt("mode", n, choices, &selected)
+	chosen := []int{}
+	for _, i := range selected {
+		if i >= 0 && i < len(modes) && !slices.Contains(chosen, i) && len(chosen) < n {
+			chosen = append(chosen, i)
+		}
+	}
+	for i := 0; i < len(modes) && len(chosen) < n; i++ {
+		if !slices.Contains(chosen, i) {
+			chosen = append(chosen, i)
+		}
+	}
+	slices.Sort(chosen)
+	return chosen
+}
+
+// order asks the player to put the cards in order, the answer lists the
+// indexes of the cards from first to last. Anything but a permutation keeps
+// the cards in the order they are in.
+func (p *Player) order(cards []any) []any {
+	selected := []int{}
+	if !p.prompt("order", len(cards), cards, &selected) || len(selected) != len(cards) {
+		return cards
+	}
+	ordered := make([]any, len(cards))
+	for i, j := range selected {
+		if j < 0 || j >= len(cards) || slices.Contains(ordered[:i], cards[j]) {
+			return cards
+		}
+		ordered[i] = cards[j]
+	}
+	return ordered
+}
+
 func (p *Player) freeFields(card *CardInstance) []any {
 	choices := []any{}
 	for i, card := range p.board.Slots {
@@ -696,6 +1180,8 @@ type EffectInstance struct {
 	Effect   Effect
 	Match    Match
 	Zone     *ZoneMatch
+	Optional bool
+	guards   []guard
 	matches  []any
 	zones    []Zone
 }
@@ -710,9 +1196,16 @@ type AbilityInstance struct {
 	Targeting  []any
 	Field      int
 	X          int
+	Modes      []int
 	Event      EventType
+	// Copy is set for copies of stack items, which have no card of their own
+	Copy bool
+	// id identifies the item once it is on the stack, so it can be targeted
+	id int
 }
 
+func (a *AbilityInstance) GetId() int { return a.id }
+
 func NewAbilityInstance(p *Player, c *CardInstance, f Ability) *AbilityInstance {
 	return &AbilityInstance{
 		Source:     c,
@@ -727,16 +1220,65 @@ func NewAbilityInstance(p *Player, c *CardInstance, f Ability) *AbilityInstance
 }
 
 func (a *AbilityInstance) Resolve() {
-	a.Controller.game.resolving = a
-	if len(a.Effects) == 0 {
+	g := a.Controller.game
+	// Essence abilities can resolve while paying for another ability
+	resolving := g.resolving
+	g.resolving = a
+	if a.Ability == nil && !a.Copy && !a.Source.IsSpell() {
 		// Cast ability
 		a.Source.activated = true //TODO: check if card enters deactivated
 		a.Controller.Place(a.Source, ZoneBoard, a.Field)
 	}
-	for _, e := range a.Effects {
-		e.Effect.Resolve(&e)
+	checked := map[*clause]bool{}
+	for i := range a.Effects {
+		e := &a.Effects[i]
+		if !e.allowed(checked) {
+			continue
+		}
+		if e.Optional && !e.confirm() {
+			// Declining skips only this effect
+			continue
+		}
+		if e.Match != nil && !e.Match.HasTarget() {
+			// Objects that are not targeted are the ones matching on resolution
+			e.matches = g.Query(a, e.Match, e.Zone, -1)
+		}
+		e.Effect.Resolve(e)
+	}
+	if a.Ability == nil && !a.Copy && a.Source.IsSpell() {
+		a.Source.Owner.Place(a.Source, ZonePile, -1)
+	}
+	g.resolving = resolving
+}
+
+// allowed checks the conditions the effect depends on, checked holds the
+// outcome of the conditions checked before during the resolution.
+func (e *EffectInstance) allowed(checked map[*clause]bool) bool {
+	for _, g := range e.guards {
+		holds, ok := checked[g.clause]
+		if !ok {
+			holds = g.clause.holds(e.Ability)
+			checked[g.clause] = holds
+		}
+		if holds == g.negate {
+			return false
+		}
 	}
-	a.Controller.game.resolving = nil
+	return true
+}
+
+// confirm asks the players among the subjects of an optional effect whether
+// they do it and keeps the subjects that do.
+func (e *EffectInstance) confirm() bool {
+	subjects := []any{}
+	for _, s := range e.Subjects {
+		if p, ok := s.(*Player); ok && !p.confirm(e.Ability.Source) {
+			continue
+		}
+		subjects = append(subjects, s)
+	}
+	e.Subjects = subjects
+	return len(subjects) > 0
 }
 
 type Phase struct {
@@ -757,71 +1299,284 @@ type EventHandler func(*Event)
 
 type GameState struct {
 	Players       []*Player
+	seed          int64
+	source        *countingSource
+	rng           *rand.Rand
 	stack         Stack
 	turn          *Turn
 	currentEvent  EventType
 	resolving     *AbilityInstance
 	eventHandlers map[EventType][]EventHandler
 	currentId     int
+	recording     *Replay
+	ctx           context.Context
+	timeout       time.Duration
+	onTimeout     TimeoutPolicy
+	timeoutRng    *rand.Rand
+	result        *GameResult
+	timestamp     int
+	layering      bool
+	damageRule    DamageRule
+}
+
+type DamageRule int8
+
+const (
+	// DamageEndOfTurn removes damage from all units at the end of every turn.
+	DamageEndOfTurn DamageRule = iota
+	// DamageStartOfTurn removes damage from units at the start of their
+	// controller's turn.
+	DamageStartOfTurn
+	// DamagePersists keeps damage on units until they are healed or leave
+	// the board.
+	DamagePersists
+)
+
+// SetDamageRule sets when damage marked on units is removed. Unused damage
+// prevention is removed together with the damage.
+func (g *GameState) SetDamageRule(rule DamageRule) {
+	g.damageRule = rule
+}
+
+type EndReason int8
+
+const (
+	EndError EndReason = iota
+	EndCancelled
+	EndForfeit
+	EndLife
+	EndDeck
+	EndConcede
+	EndDraw
+)
+
+func (r EndReason) String() string {
+	switch r {
+	case EndError:
+		return "error"
+	case EndCancelled:
+		return "cancelled"
+	case EndForfeit:
+		return "forfeit"
+	case EndLife:
+		return "life"
+	case EndDeck:
+		return "deck"
+	case EndConcede:
+		return "concede"
+	case EndDraw:
+		return "draw"
+	}
+	return "unknown"
+}
+
+// GameResult describes how a game ended.
+type GameResult struct {
+	Reason EndReason
+	Winner *Player
+	Losers []*Player
+	Turns  int
+	Err    error
 }
 
-func NewGame(players ...*Player) *GameState {
+var (
+	ErrInvalidChoice = errors.New("invalid choice")
+	ErrConcede       = errors.New("conceded")
+)
+
+// NewGame creates a game whose random decisions are all derived from seed,
+// so the same seed and the same player messages always produce the same game.
+func NewGame(seed int64, players ...*Player) *GameState {
 	g := &GameState{
 		Players:       players,
+		seed:          seed,
+		source:        newCountingSource(seed, 0),
 		stack:         Stack{cards: []*AbilityInstance{}},
 		eventHandlers: map[EventType][]EventHandler{},
 	}
+	g.rng = rand.New(g.source)
 	for _, player := range g.Players {
 		player.game = g
 	}
 	return g
 }
 
-func (g *GameState) Run() {
+// Run plays the game until it ends, see RunContext.
+func (g *GameState) Run() *GameResult {
+	return g.RunContext(context.Background())
+}
+
+// RunContext plays the game until it ends or ctx is done. A restored game
+// continues from the turn and phase it was saved in.
+func (g *GameState) RunContext(ctx context.Context) *GameResult {
 	if len(g.Players) == 0 {
 		panic("No players")
 	}
-	nrPlayers := len(g.Players)
-	beginningPlayer := rand.Intn(nrPlayers)
-	for i := 0; i < nrPlayers; i++ {
-		g.Players[(beginningPlayer+i)%nrPlayers].nr = i + 1
-	}
-	turn := 1
-	p := g.Players[beginningPlayer]
-	for _, player := range g.Players {
-		player.life = startLife
-		player.Draw(startCards)
+	g.ctx = ctx
+	g.result = nil
+	if g.turn == nil {
+		g.turn = &Turn{g, g.start(), nil, 1, 0}
 	}
 	for {
-		g.turn = &Turn{g, p, nil, turn, 0}
 		for phase := range g.turn.Iter() {
 			for player := range phase.Iter() {
 				if !player.Run() {
-					return
+					g.stop(ErrInvalidChoice)
+					return g.result
 				}
 			}
+			if g.result != nil {
+				return g.result
+			}
 		}
-		turn += 1
-		if p.turnsAfter > 0 {
+		p := g.turn.player
+		if p.turnsAfter > 0 && !p.lost {
 			p.turnsAfter -= 1
 		} else {
 			p = g.nextPlayer(p)
 		}
+		g.turn = &Turn{g, p, nil, g.turn.turn + 1, 0}
+	}
+}
+
+// stop ends the game because a prompt failed with err.
+func (g *GameState) stop(err error) {
+	if g.result != nil {
+		return
+	}
+	g.result = &GameResult{Reason: EndError, Losers: []*Player{}, Err: err}
+	if g.turn != nil {
+		g.result.Turns = g.turn.turn
+	}
+	if g.ctx != nil && g.ctx.Err() != nil {
+		g.result.Reason = EndCancelled
 	}
 }
 
+// checkState performs state based actions until nothing changes anymore:
+// units without health go to the pile, tokens that left the board cease to
+// exist and players lose. It runs whenever a player would receive priority
+// and reports whether the game is over.
+func (g *GameState) checkState() bool {
+	for {
+		changed := false
+		dead := []*CardInstance{}
+		for _, p := range g.Players {
+			for _, card := range p.board.Slots {
+				// TODO: check invurnability
+				if card != nil && (card.destroyed || card.stats != nil && card.GetHealth().Number <= 0) {
+					dead = append(dead, card)
+				}
+			}
+		}
+		for _, card := range dead {
+			card.Owner.Place(card, ZonePile, -1)
+			card.Owner.Emit(EventOnDestroy, card)
+			changed = true
+		}
+		for _, p := range g.Players {
+			for _, pile := range []*Pile{&p.deck, &p.hand, &p.pile} {
+				for _, card := range slices.Clone(pile.Cards) {
+					if card.HasType("token") {
+						pile.Remove(card)
+						card.zone = ZoneAny
+						changed = true
+					}
+				}
+			}
+		}
+		if g.checkLosses() {
+			return true
+		}
+		if !changed {
+			return false
+		}
+	}
+}
+
+// checkLosses makes players at 0 life or with a pending loss lose and ends
+// the game when at most one player is left. Players losing at the same time
+// with nobody left is a draw. It reports whether the game is over.
+func (g *GameState) checkLosses() bool {
+	if g.result != nil {
+		return true
+	}
+	losers := []*Player{}
+	for _, p := range g.Players {
+		if p.lost {
+			continue
+		}
+		if p.life <= 0 {
+			p.lose(EndLife)
+		}
+		if p.losing {
+			p.lost = true
+			losers = append(losers, p)
+		}
+	}
+	for _, p := range losers {
+		p.Emit(EventOnLose, p.loss)
+	}
+	left := []*Player{}
+	for _, p := range g.Players {
+		if !p.lost {
+			left = append(left, p)
+		}
+	}
+	if len(losers) == 0 || len(left) > 1 {
+		return false
+	}
+	g.result = &GameResult{Reason: losers[0].loss, Losers: []*Player{}}
+	if g.turn != nil {
+		g.result.Turns = g.turn.turn
+	}
+	for _, p := range g.Players {
+		if p.lost {
+			g.result.Losers = append(g.result.Losers, p)
+		}
+	}
+	if len(left) == 1 {
+		g.result.Winner = left[0]
+		left[0].Emit(EventOnWin)
+	} else if len(losers) > 1 {
+		g.result.Reason = EndDraw
+	}
+	return true
+}
+
+func (g *GameState) start() *Player {
+	nrPlayers := len(g.Players)
+	beginningPlayer := g.rng.Intn(nrPlayers)
+	for i := 0; i < nrPlayers; i++ {
+		g.Players[(beginningPlayer+i)%nrPlayers].nr = i + 1
+	}
+	for _, player := range g.Players {
+		player.life = startLife
+		player.Draw(startCards)
+	}
+	return g.Players[beginningPlayer]
+}
+
+func (g *GameState) Seed() int64 { return g.seed }
+
+// Rand returns the game's random number generator. Anything that influences
+// the game state must draw from it to keep games reproducible.
+func (g *GameState) Rand() *rand.Rand { return g.rng }
+
 func (g *GameState) AddPlayer(deck ...*Card) *Player {
 	g.currentId += 1
 	p := &Player{
-		Id:      g.currentId,
-		game:    g,
-		life:    0,
-		deck:    Pile{Cards: []*CardInstance{}},
-		hand:    Pile{Cards: []*CardInstance{}},
-		pile:    Pile{Cards: []*CardInstance{}},
-		board:   Board{Slots: make([]*CardInstance, boardSize)},
-		essence: []string{},
-		msgChan: make(chan Msg),
+		Id:       g.currentId,
+		game:     g,
+		life:     0,
+		deck:     Pile{Cards: []*CardInstance{}},
+		hand:     Pile{Cards: []*CardInstance{}},
+		pile:     Pile{Cards: []*CardInstance{}},
+		board:    Board{Slots: make([]*CardInstance, boardSize)},
+		essence:  []string{},
+		decklist: deck,
+		decider:  ChannelDecider{},
+		msgChan:  make(chan Msg, 1),
 	}
 	for _, card := range deck {
 		p.deck.Add(NewCardInstance(card, p, ZoneDeck))
@@ -867,6 +1622,8 @@ func (g *GameState) IsReaction() bool {
 }
 
 func (g *GameState) Play(a *AbilityInstance) {
+	g.currentId += 1
+	a.id = g.currentId
 	// Emit event after ability is on the stack
 	g.Emit(EventOnStack, a.Controller, a)
 	for i := 0; i < len(a.Effects); i++ {
@@ -885,7 +1642,9 @@ func (g *GameState) Pick(a *AbilityInstance, o Match, z *ZoneMatch) []any {
 			return a.Targeting
 		}
 		targeted := []int{}
-		for !a.Controller.prompt("target", 1, found, &targeted) {}
+		if !a.Controller.prompt("target", 1, found, &targeted) {
+			return a.Targeting
+		}
 		for _, i := range targeted {
 			if i >= 0 && i < len(found) {
 				a.Targeting = append(a.Targeting, found[i])
@@ -901,7 +1660,9 @@ func (g *GameState) Pick(a *AbilityInstance, o Match, z *ZoneMatch) []any {
 				return a.Targeting
 			}
 			targeted := []int{}
-			for !a.Controller.prompt("target", 1, found, &targeted) {}
+			if !a.Controller.prompt("target", 1, found, &targeted) {
+				return a.Targeting
+			}
 			for _, i := range targeted {
 				if i >= 0 && i < len(found) {
 					a.Targeting = append(a.Targeting, found[i])
@@ -928,65 +1689,193 @@ func (g *GameState) Query(a *AbilityInstance, o Match, z *ZoneMatch, n int) []an
 	if n > 0 {
 		return found[:n]
 	}
-	return found
+	return found
+}
+
+func (t *Turn) Iter() iter.Seq[*Phase] {
+	return func(yield func(*Phase) bool) {
+		start := PhaseStart
+		if t.phase != nil {
+			// Resuming a restored turn, the current phase has already begun.
+			start = t.phase.phase
+		}
+		for phase := start; phase <= PhaseEnd; phase++ {
+			if t.phase == nil || t.phase.phase != phase {
+				t.begin(phase)
+			}
+			if !yield(t.phase) {
+				return
+			}
+		}
+		t.cleanup()
+	}
+}
+
+// combat is the combat step after the play phase: the defending player
+// declares blockers for the units that attacked this turn, after which all
+// combat damage is dealt at the same time.
+func (t *Turn) combat() {
+	attackers := []*CardInstance{}
+	for _, card := range t.player.board.Slots {
+		if card != nil && card.attacking {
+			card.attacking = false
+			attackers = append(attackers, card)
+		}
+	}
+	defender := t.game.nextPlayer(t.player)
+	if len(attackers) == 0 || defender == nil || defender == t.player {
+		return
+	}
+	blocks := defender.block(attackers)
+	type hit struct {
+		source *CardInstance
+		target any
+		amount int
+	}
+	hits := []hit{}
+	for _, attacker := range attackers {
+		power := attacker.GetPower().Number
+		blocker, blocked := blocks[attacker]
+		if blocked {
+			hits = append(hits, hit{attacker, blocker, power}, hit{blocker, attacker, blocker.GetPower().Number})
+		}
+		if !blocked || attacker.HasKeyword("siege") {
+			hits = append(hits, hit{attacker, defender, power})
+		}
+	}
+	for _, h := range hits {
+		h.source.DealDamage(h.target, h.amount)
+	}
+}
+
+// cleanup ends the effects that last until the end of the turn.
+func (t *Turn) cleanup() {
+	t.game.expire(func(m Mods) bool { return m.EndOfTurn == t.turn })
+	if t.game.damageRule == DamageEndOfTurn {
+		t.game.clearDamage(func(*CardInstance) bool { return true })
+	}
 }
 
-func (t *Turn) Iter() iter.Seq[*Phase] {
-	return func(yield func(*Phase) bool) {
-		t.phase = &Phase{t, t.player, PhaseStart}
+func (t *Turn) begin(phase PhaseType) {
+	t.phase = &Phase{t, t.player, phase}
+	switch phase {
+	case PhaseStart:
+		t.game.expire(func(m Mods) bool { return m.NextTurnOf == t.player.Id })
+		if t.game.damageRule == DamageStartOfTurn {
+			t.game.clearDamage(func(c *CardInstance) bool { return c.Controller == t.player })
+		}
 		t.player.Emit(EventAtStartPhase)
 		for _, card := range t.player.board.Slots {
 			if card != nil {
 				card.Activate()
 			}
 		}
-		if !yield(t.phase) {
-			return
-		}
-		t.phase = &Phase{t, t.player, PhaseDraw}
+	case PhaseDraw:
 		t.player.Emit(EventAtDrawPhase)
 		t.player.Draw(1)
-		if !yield(t.phase) {
-			return
-		}
-		t.phase = &Phase{t, t.player, PhasePlay}
+	case PhasePlay:
 		t.player.Emit(EventAtPlayPhase)
-		if !yield(t.phase) {
-			return
-		}
-		t.phase = &Phase{t, t.player, PhaseEnd}
+	case PhaseEnd:
+		t.combat()
 		t.player.Emit(EventAtEndPhase)
 		t.player.ClearEssence()
-		if !yield(t.phase) {
-			return
-		}
 	}
 }
 
+// Iter gives every player priority in turn. When something is put on the
+// stack every other player can respond before the last ability on the stack
+// resolves, after which the active player gets priority again.
 func (p *Phase) Iter() iter.Seq[*Player] {
 	return func(yield func(*Player) bool) {
+		g := p.turn.game
 		for {
-			for i := 0; i < len(p.turn.game.Players); i++ {
-				if !yield(p.priority) {
+			for passed := 0; passed < len(g.Players); passed++ {
+				if g.checkState() {
+					return
+				}
+				size := len(g.stack.cards)
+				if !p.priority.lost && !yield(p.priority) {
 					return
 				}
-				p.priority = p.turn.game.nextPlayer(p.priority)
+				if len(g.stack.cards) > size {
+					// Everybody else gets to respond
+					passed = 0
+				}
+				p.priority = g.nextPlayer(p.priority)
 			}
-			if len(p.turn.game.stack.cards) == 0 {
+			if len(g.stack.cards) == 0 {
 				return
 			}
-			p.turn.game.stack.Pop().Resolve()
+			g.stack.Pop().Resolve()
+			if !p.turn.player.lost {
+				p.priority = p.turn.player
+			}
 		}
 	}
 }
 
-func (g *GameState) nextPlayer(p *Player) *Player {
+func (g *GameState) playerIndex(p *Player) int {
 	for i, player := range g.Players {
 		if player == p {
-			return g.Players[(i+1)%len(g.Players)]
+			return i
 		}
 	}
-	return nil
+	return -1
+}
+
+// nextPlayer returns the first player after p that is still in the game.
+func (g *GameState) nextPlayer(p *Player) *Player {
+	i := g.playerIndex(p)
+	if i < 0 {
+		return nil
+	}
+	for j := 1; j < len(g.Players); j++ {
+		if next := g.Players[(i+j)%len(g.Players)]; !next.lost {
+			return next
+		}
+	}
+	return p
+}
+
+// expire removes the modifiers for which expired returns true from all cards.
+func (g *GameState) expire(expired func(Mods) bool) {
+	for _, p := range g.Players {
+		for _, cards := range [][]*CardInstance{p.deck.Cards, p.hand.Cards, p.pile.Cards, p.board.Slots} {
+			for _, card := range cards {
+				if card != nil {
+					card.modifier = slices.DeleteFunc(card.modifier, expired)
+				}
+			}
+		}
+	}
+}
+
+// clearDamage removes the damage and damage prevention from the units on the
+// board for which clear returns true.
+func (g *GameState) clearDamage(clear func(*CardInstance) bool) {
+	for _, p := range g.Players {
+		for _, card := range p.board.Slots {
+			if card != nil && clear(card) {
+				card.damage = 0
+				card.prevent = 0
+			}
+		}
+	}
+}
+
+// staticSources returns the cards on the board with static abilities in the
+// order they entered the board.
+func (g *GameState) staticSources() []*CardInstance {
+	sources := []*CardInstance{}
+	for _, p := range g.Players {
+		for _, card := range p.board.Slots {
+			if card != nil && !card.flipped && len(card.GetStaticAbilities()) > 0 {
+				sources = append(sources, card)
+			}
+		}
+	}
+	slices.SortFunc(sources, func(a, b *CardInstance) int { return a.timestamp - b.timestamp })
+	return sources
 }
 
 func (g *GameState) GetStackAbilities() []*AbilityInstance {
@@ -1002,17 +1891,21 @@ func NewCardParser() *CardParser {
 		participle.Lexer(lexer.MustSimple([]lexer.SimpleRule{
 			{"whitespace", `[\s]+`},
 			{"Ident", `[a-zA-Z]\w*`},
-			{"Punct", `[-+,{}/:.]`},
+			{"Punct", `'s|[-+,{}/:.—•]`},
 			{"Int", `\d+`},
 		})),
 		//participle.UseLookahead(2),
 		participle.Union[Ability](
 			Keyword{},
-			Composed{},
 			Activated{},
+			Composed{},
 			Triggered{},
 		),
 		participle.Union[Effect](
+			Modal{},
+			Conditional{},
+			Otherwise{},
+			Unless{},
 			PlayerSubjectAbility{},
 			CardSubjectAbility{},
 		),
@@ -1028,16 +1921,23 @@ func NewCardParser() *CardParser {
 			Add{},
 			GainLife{},
 			LoseLife{},
+			Heal{},
+			Prevent{},
 			Discard{},
 			Shuffle{},
 			ExtraTurn{},
 			Look{},
+			PutCounter{},
+			RemoveCounter{},
 			Put{},
 			Activate{},
 			Deactivate{},
 			Sacrifice{},
 			PayEssence{},
 			PayLife{},
+			CounterSpell{},
+			ReturnSpell{},
+			CopySpell{},
 		),
 		participle.Union[CardEffect](
 			Damage{},
@@ -1060,7 +1960,15 @@ func (p *CardParser) Parse(txt string, include_text bool) (*Card, error) {
 	if include_text {
 		card.Text = txt
 		// TODO: this brakes if there are multiple abilities on the same line (e.g. the keywords are at the end instead of the beginning)
-		lines := strings.Split(txt, "\n")
+		lines := []string{}
+		for _, line := range strings.Split(txt, "\n") {
+			if len(lines) > 0 && strings.HasPrefix(strings.TrimSpace(line), "•") {
+				// Modes continue the ability on the line before them
+				lines[len(lines)-1] += "\n" + strings.TrimSpace(line)
+			} else {
+				lines = append(lines, line)
+			}
+		}
 		if card.Stats != nil {
 			lines = lines[:len(lines)-1]
 		}
@@ -1072,12 +1980,15 @@ func (p *CardParser) Parse(txt string, include_text bool) (*Card, error) {
 			}
 			if a, ok := card.Abilities[j].(Activated); ok {
 				a.text = line
+				setModeText(a.Effect.Effects, line)
 				card.Abilities[j] = a
 			} else if a, ok := card.Abilities[j].(Triggered); ok {
 				a.text = line
+				setModeText(a.Effect.Effects, line)
 				card.Abilities[j] = a
 			} else if a, ok := card.Abilities[j].(Composed); ok {
 				a.text = line
+				setModeText(a.Effects, line)
 				card.Abilities[j] = a
 			}
 		}
@@ -1088,7 +1999,7 @@ func (p *CardParser) Parse(txt string, include_text bool) (*Card, error) {
 type NumberOrX struct {
 	Number int  `@Int`
 	A      bool `| @("a"|"an")`
-	X      bool `| @"X"`
+	X      bool `| @("x"|"X")`
 }
 
 func (n NumberOrX) String() string {
@@ -1109,16 +2020,54 @@ func (n NumberOrX) Value(a *AbilityInstance) int {
 	return n.Number
 }
 
+// Scale makes the amount of an effect depend on the state of the game when
+// it resolves: "where X is" gives X a value, "equal to" replaces the amount
+// and "for each" multiplies it.
+type Scale struct {
+	Where   *Count     `( ","? "where" ("x"|"X") "is" @@`
+	EqualTo *Count     `| "equal" "to" @@`
+	ForEach *CardMatch `| "for" "each" @@`
+	Zone    *ZoneMatch `("in" @@)? )`
+}
+
+func (s *Scale) Value(a *AbilityInstance, n NumberOrX) int {
+	if s == nil {
+		return n.Value(a)
+	}
+	if s.Where != nil {
+		if n.X {
+			return s.Where.Value(a)
+		}
+		return n.Value(a)
+	}
+	if s.EqualTo != nil {
+		return s.EqualTo.Value(a)
+	}
+	return n.Value(a) * Count{Objects: s.ForEach, Zone: s.Zone}.Value(a)
+}
+
+// CostType is a cost symbol. Hybrid symbols like {s/o} can be paid with any
+// of their colors and the wild symbol {u} with any essence.
 type CostType struct {
-	Color      string    `"{"( @("c"|"o"|"s"|"w")`
+	Color      string    `"{"( @("c"|"o"|"s"|"w"|"u")`
+	Hybrid     []string  `("/" @("c"|"o"|"s"|"w"))*`
 	Activate   bool      `| @"q"`
 	Deactivate bool      `| @"t"`
 	Number     NumberOrX `| @@ )"}"`
 }
 
+// Colors returns the essence that can pay for the symbol, nil if any
+// essence can.
+func (c CostType) Colors() []string {
+	if c.Color == "" || c.Color == "u" {
+		return nil
+	}
+	return append([]string{c.Color}, c.Hybrid...)
+}
+
 func (c CostType) String() string {
 	if c.Color != "" {
-		return fmt.Sprintf("{%s}", c.Color)
+		return fmt.Sprintf("{%s}", strings.Join(append([]string{c.Color}, c.Hybrid...), "/"))
 	} else if c.Activate {
 		return "{q}"
 	} else if c.Deactivate {
@@ -1128,10 +2077,15 @@ func (c CostType) String() string {
 }
 
 func (c CostType) Pay(a *AbilityInstance, p *Player) bool {
-	if c.Color != "" {
-		if !p.RemoveEssence(c.Color) {
-			return false
+	if c.Color == "u" {
+		return p.RemoveEssence("u")
+	} else if c.Color != "" {
+		for _, color := range c.Colors() {
+			if p.RemoveEssence(color) {
+				return true
+			}
 		}
+		return false
 	} else {
 		n := c.Number.Value(a)
 		for i := 0; i < n; i++ {
@@ -1148,15 +2102,25 @@ type Color struct {
 }
 
 type CardType struct {
-	Value string `@(
+	Value TypeName `@(
 "unit"|"units"|
 "item"|"items"|
 "source"|"sources"|
 "spell"|"spells"|
+"reaction"|"reactions"|
 "token"|"tokens"
 )`
 }
 
+// TypeName is the name of a card type. Plurals are captured as the singular,
+// so "units" and "unit" compare equal.
+type TypeName string
+
+func (t *TypeName) Capture(values []string) error {
+	*t = TypeName(strings.TrimSuffix(values[0], "s"))
+	return nil
+}
+
 type SubType struct {
 	Value string `@(
 "human"|
@@ -1185,23 +2149,42 @@ type Stats struct {
 }
 
 type Modifier interface {
-	Apply(*CardInstance)
-	Reverse(*CardInstance)
+	Apply(*Stats)
+	Reverse(*Stats)
 }
 
 type Mods struct {
 	Power  int
 	Health int
+	// The modifier ends in the end phase of turn EndOfTurn or at the start of
+	// the next turn of the player with id NextTurnOf, zero means never.
+	EndOfTurn  int
+	NextTurnOf int
 }
 
-func (m Mods) Apply(c *CardInstance) {
-	c.stats.Power.Number += m.Power
-	c.stats.Health.Number += m.Health
+// Duration limits how long an effect lasts.
+type Duration struct {
+	EndOfTurn bool `@("until" "end" "of" "turn" | "this" "turn")`
+	NextTurn  bool `| @("until" "your" "next" "turn")`
 }
 
-func (m Mods) Reverse(c *CardInstance) {
-	c.stats.Power.Number -= m.Power
-	c.stats.Health.Number -= m.Health
+// Expire sets when the modifier made by ability a ends.
+func (d Duration) Expire(a *AbilityInstance, m *Mods) {
+	if d.NextTurn {
+		m.NextTurnOf = a.Controller.Id
+	} else if d.EndOfTurn {
+		m.EndOfTurn = a.Controller.game.turn.turn
+	}
+}
+
+func (m Mods) Apply(s *Stats) {
+	s.Power.Number += m.Power
+	s.Health.Number += m.Health
+}
+
+func (m Mods) Reverse(s *Stats) {
+	s.Power.Number -= m.Power
+	s.Health.Number -= m.Health
 }
 
 type Card struct {
@@ -1219,6 +2202,7 @@ type CardInstance struct {
 	ID         int
 	Card       *Card
 	activated  bool
+	attacking  bool
 	flipped    bool
 	zone       Zone
 	index      int
@@ -1226,6 +2210,11 @@ type CardInstance struct {
 	Controller *Player
 	stats      *Stats
 	modifier   []Mods
+	destroyed  bool
+	timestamp  int
+	damage     int
+	prevent    int
+	counters   map[string]int
 }
 
 func NewCardInstance(card *Card, owner *Player, zone Zone) *CardInstance {
@@ -1248,18 +2237,95 @@ func (c *CardInstance) GetId() int { return c.ID }
 
 func (c *CardInstance) GetName() string { return c.Card.Name }
 
-func (c *CardInstance) GetPower() NumberOrX {
+// GetStats computes the current stats of the card: the base stats, then the
+// static abilities on the board in timestamp order, then the modifiers and
+// last the counters.
+func (c *CardInstance) GetStats() *Stats {
 	if c.stats == nil {
-		return NumberOrX{}
+		return nil
+	}
+	s := *c.stats
+	g := c.Owner.game
+	if c.zone == ZoneBoard && !g.layering {
+		// Matches of static abilities see the stats without static abilities
+		g.layering = true
+		for _, source := range g.staticSources() {
+			for _, a := range source.GetStaticAbilities() {
+				a.(Composed).apply(source, c, &s)
+			}
+		}
+		g.layering = false
+	}
+	for _, m := range c.modifier {
+		m.Apply(&s)
+	}
+	for kind, n := range c.counters {
+		var power, health int
+		if _, err := fmt.Sscanf(kind, "%d/%d", &power, &health); err == nil {
+			Mods{Power: power * n, Health: health * n}.Apply(&s)
+		}
+	}
+	return &s
+}
+
+func (c *CardInstance) GetPower() NumberOrX {
+	if s := c.GetStats(); s != nil {
+		return s.Power
 	}
-	return c.stats.Power
+	return NumberOrX{}
 }
 
+// GetHealth returns the health the card has left after the damage marked on
+// it.
 func (c *CardInstance) GetHealth() NumberOrX {
-	if c.stats == nil {
-		return NumberOrX{}
+	if s := c.GetStats(); s != nil {
+		health := s.Health
+		health.Number -= c.damage
+		return health
+	}
+	return NumberOrX{}
+}
+
+func (c *CardInstance) GetDamage() int { return c.damage }
+
+// GetCounters returns the number of counters of a kind on the card, an empty
+// kind counts all counters.
+func (c *CardInstance) GetCounters(kind string) int {
+	if kind != "" {
+		return c.counters[kind]
+	}
+	n := 0
+	for _, count := range c.counters {
+		n += count
+	}
+	return n
+}
+
+// AddCounter puts n counters of a kind on the card.
+func (c *CardInstance) AddCounter(kind string, n int) {
+	if n <= 0 {
+		return
+	}
+	if c.counters == nil {
+		c.counters = map[string]int{}
+	}
+	c.counters[kind] += n
+	c.Owner.Emit(EventOnCounter, c, kind, n)
+}
+
+// RemoveCounter removes up to n counters of a kind from the card, a negative
+// n removes all of them.
+func (c *CardInstance) RemoveCounter(kind string, n int) {
+	if n < 0 || n > c.counters[kind] {
+		n = c.counters[kind]
+	}
+	if n == 0 {
+		return
+	}
+	if c.counters[kind] -= n; c.counters[kind] == 0 {
+		delete(c.counters, kind)
 	}
-	return c.stats.Health
+	c.Owner.Emit(EventOnCounter, c, kind, -n)
 }
 
 func (c *CardInstance) GetTypes() []CardType {
@@ -1317,8 +2383,13 @@ func (c *CardInstance) GetTriggeredAbilities() []*Triggered {
 	return abilities
 }
 
+// GetStaticAbilities returns the abilities that apply while the card is on the
+// board, the abilities of a spell are its effects instead.
 func (c *CardInstance) GetStaticAbilities() []Ability {
 	abilities := []Ability{}
+	if c.IsSpell() {
+		return abilities
+	}
 	for _, a := range c.Card.Abilities {
 		if _, ok := a.(Composed); ok {
 			abilities = append(abilities, a)
@@ -1327,17 +2398,56 @@ func (c *CardInstance) GetStaticAbilities() []Ability {
 	return abilities
 }
 
-func (c *CardInstance) TakeDamage(n int) {
+// TakeDamage marks damage on the card after prevention and returns the damage
+// dealt, cards without health are destroyed by any damage. Destroyed cards
+// leave the board at the next state check.
+func (c *CardInstance) TakeDamage(n int) int {
 	// TODO: check for protection of source
+	prevented := min(max(n, 0), c.prevent)
+	if prevented > 0 {
+		c.prevent -= prevented
+		if n -= prevented; n == 0 {
+			return 0
+		}
+	}
 	c.Owner.Emit(EventOnDamage, c, n)
 	if c.stats != nil {
-		c.stats.Health.Number -= n
+		c.damage += n
+	} else if n > 0 {
+		c.destroyed = true
+	}
+	return n
+}
+
+// Heal removes up to n damage from the card, a negative n removes all damage.
+func (c *CardInstance) Heal(n int) {
+	if n < 0 || n > c.damage {
+		n = c.damage
 	}
-	if c.stats == nil || c.stats.Health.Number <= 0 {
-		// TODO: check invurnability
-		c.Controller.Remove(c)
-		c.Owner.Place(c, ZonePile, -1)
-		c.Owner.Emit(EventOnDestroy, c)
+	if n == 0 {
+		return
+	}
+	c.damage -= n
+	c.Owner.Emit(EventOnHeal, c, n)
+}
+
+// PreventDamage prevents the next n damage that would be dealt to the card.
+func (c *CardInstance) PreventDamage(n int) {
+	c.prevent += n
+}
+
+// DealDamage makes the card deal n damage to a card or a player. Damage from
+// a card with poison destroys the units it damages.
+func (c *CardInstance) DealDamage(target any, n int) {
+	switch t := target.(type) {
+	case *CardInstance:
+		n = t.TakeDamage(n)
+		if n > 0 && c != nil && c.HasKeyword("poison") {
+			t.destroyed = true
+		}
+	case *Player:
+		t.LoseLife(n)
+		t.Emit(EventOnPlayerDamage, n)
 	}
 }
 
@@ -1353,7 +2463,6 @@ func (c *CardInstance) Deactivate() {
 
 func (c *CardInstance) Do(a *Activated) *AbilityInstance {
 	player := c.Owner.game.turn.phase.priority
-	player.Pay(c, a.Cost)
 	return a.Do(player, c)
 }
 
@@ -1367,8 +2476,26 @@ func (c *CardInstance) Play(index int) {
 func (c *CardInstance) Cast(index int) *AbilityInstance {
 	player := c.Owner.game.turn.phase.priority
 	player.Remove(c)
-	player.Pay(c, c.GetCosts())
-	return &AbilityInstance{Source: c, Controller: player, Field: index}
+	c.zone = ZoneStack
+	a := &AbilityInstance{Source: c, Controller: player, Field: index}
+	a.X = player.Pay(a, c.GetCosts())
+	if c.IsSpell() {
+		c.spell(a)
+	}
+	return a
+}
+
+func (c *CardInstance) IsSpell() bool {
+	return c.HasType("spell")
+}
+
+// spell adds the effects of the spell's abilities to the ability casting it.
+func (c *CardInstance) spell(a *AbilityInstance) {
+	for _, ab := range c.Card.Abilities {
+		if composed, ok := ab.(Composed); ok {
+			composed.Do(a.Controller, a)
+		}
+	}
 }
 
 func (c *CardInstance) Trigger(event *Event) {
@@ -1382,16 +2509,38 @@ func (c *CardInstance) Trigger(event *Event) {
 
 func (c *CardInstance) CanDo() bool {
 	for _, a := range c.GetActivatedAbilities() {
-		if a.CanDo(c) && (a.IsCost() || !c.Owner.game.IsReaction()) {
+		if c.CanActivate(a) {
 			return true
 		}
 	}
 	return false
 }
 
+// CanActivate reports whether the ability of the card can be activated now,
+// essence abilities can always be activated when their costs can be paid.
+// Other abilities, attacking included, use the normal timing even when the
+// card itself is a reaction.
+func (c *CardInstance) CanActivate(a *Activated) bool {
+	return a.CanDo(c) && (a.IsCost() || c.canAct())
+}
+
+// IsReaction reports whether the card is played at reaction speed: its
+// controller can play it whenever they have priority, also in response to
+// abilities on the stack.
+func (c *CardInstance) IsReaction() bool {
+	return c.HasType("reaction") || c.HasKeyword("ambush")
+}
+
+// CanReact reports whether the card can be played at this time. Other cards
+// than reactions can only be played in their controller's play phase while
+// the stack is empty.
 func (c *CardInstance) CanReact() bool {
-	// TODO: check if card can react or instant
-	return c.Owner.game.turn.player == c.Owner && !c.Owner.game.IsReaction()
+	return c.IsReaction() || c.canAct()
+}
+
+// canAct reports whether it is the controller's play phase with an empty stack.
+func (c *CardInstance) canAct() bool {
+	return c.Owner.game.turn.player == c.Controller && !c.Owner.game.IsReaction()
 }
 
 func (c *CardInstance) CanPlay() bool {
@@ -1403,7 +2552,8 @@ func (c *CardInstance) CanPlay() bool {
 }
 
 func (c *CardInstance) CanSource() bool {
-	if c.zone != ZoneHand || !c.CanReact() {
+	g := c.Owner.game
+	if c.zone != ZoneHand || g.turn.player != c.Owner || g.IsReaction() {
 		return false
 	}
 	t := c.Owner.game.turn
@@ -1427,9 +2577,10 @@ func (c *CardInstance) HasKeyword(k string) bool {
 	return false
 }
 
+// HasType reports whether the card has type t.
 func (c *CardInstance) HasType(t string) bool {
 	for _, ct := range c.GetTypes() {
-		if ct.Value == t {
+		if string(ct.Value) == t {
 			return true
 		}
 	}
@@ -1447,7 +2598,7 @@ func (c *CardInstance) HasSubType(t string) bool {
 
 func (c *CardInstance) HasColor(t string) bool {
 	for _, ct := range c.Card.Costs {
-		if ct.Color == t {
+		if ct.Color == t || slices.Contains(ct.Hybrid, t) {
 			return true
 		}
 	}
@@ -1462,92 +2613,278 @@ func (f Attack) Do(a *EffectInstance) {}
 func (f Attack) Resolve(e *EffectInstance) {
 	for _, c := range e.Subjects {
 		card := c.(*CardInstance)
-		// Emit attack event
+		if card.zone != ZoneBoard {
+			continue
+		}
+		// Declare the attacker, combat damage is dealt in the combat step
+		card.attacking = true
 		card.Controller.game.Emit(EventOnAttack, card.Controller, card)
+	}
+}
+
+func createEssenceAbility(color string) *Activated {
+	return &Activated{
+		[]AbilityCost{{Cost: &CostType{Deactivate: true}}},
+		Composed{[]Effect{PlayerSubjectAbility{
+			nil, false, []PlayerEffect{Add{[]CostType{{Color: color}}}},
+		}}, ""},
+		fmt.Sprintf("{T}: Add {%s} essence", strings.ToUpper(color)),
+	}
+}
+
+var SEssenseAbility = createEssenceAbility("s")
+var OEssenseAbility = createEssenceAbility("o")
+var CEssenseAbility = createEssenceAbility("c")
+var WEssenseAbility = createEssenceAbility("w")
+
+var AttackAbility = &Activated{
+	[]AbilityCost{{Cost: &CostType{Deactivate: true}}},
+	Composed{[]Effect{CardSubjectAbility{nil, []CardEffect{Attack{}}}}, ""},
+	"Attack",
+}
+
+type Ability interface{ Text() string }
+
+type Effect interface {
+	HasTarget() bool
+	IsCost() bool
+	Do(*EffectInstance)
+	Resolve(*EffectInstance)
+}
+type CardEffect interface {
+	HasTarget() bool
+	IsCost() bool
+	Do(*EffectInstance)
+	Resolve(*EffectInstance)
+}
+type PlayerEffect interface {
+	HasTarget() bool
+	IsCost() bool
+	Do(*EffectInstance)
+	Resolve(*EffectInstance)
+}
+
+type Keyword struct {
+	Value string `@("fly"|"siege"|"poison"|"ambush")`
+}
+
+func (f Keyword) Text() string { return f.Value }
+
+type AbilityCost struct {
+	Cost   *CostType `@@`
+	Action *Effect   `| @@`
+}
+
+type Composed struct {
+	Effects []Effect `@@ (("," ("then"|"and")? | "." (?= ("if"|"otherwise"))) @@ | (?= "unless") @@)* "."`
+	text    string
+}
+
+func (f Composed) Text() string { return f.text }
+
+func (f Composed) Do(p *Player, a *AbilityInstance) {
+	doEffects(a, f.Effects)
+}
+
+// doEffects adds the instances of the effects to the ability. Conditions
+// guard the instances of the effects they apply to: "if" the effects after
+// it and with "instead" the effect before it, "otherwise" the effects after
+// it and "unless" the effect before it.
+func doEffects(a *AbilityInstance, effects []Effect) {
+	var last *clause
+	prev := len(a.Effects)
+	for _, e := range effects {
+		start := len(a.Effects)
+		e.Do(&EffectInstance{Ability: a, Effect: e})
+		switch ef := e.(type) {
+		case Conditional:
+			last = &clause{If: &ef.If}
+			guardEffects(a.Effects[start:], last, false)
+			if ef.Instead {
+				guardEffects(a.Effects[prev:start], last, true)
+			}
+		case Otherwise:
+			if last != nil {
+				guardEffects(a.Effects[start:], last, true)
+			}
+		case Unless:
+			guardEffects(a.Effects[prev:start], &clause{Unless: &ef}, true)
+		}
+		prev = start
+	}
+}
+
+// clause is a condition some effects of an ability depend on, it is checked
+// once when the ability resolves.
+type clause struct {
+	If     *Condition
+	Unless *Unless
+}
+
+func (c *clause) holds(a *AbilityInstance) bool {
+	if c.Unless != nil {
+		return c.Unless.holds(a)
+	}
+	return c.If.Match(a, a.Source)
+}
+
+type guard struct {
+	clause *clause
+	negate bool
+}
+
+func guardEffects(effects []EffectInstance, c *clause, negate bool) {
+	for i := range effects {
+		effects[i].guards = append(effects[i].guards, guard{c, negate})
+	}
+}
+
+// apply applies the continuous effects of a static ability of source to the
+// stats s of card.
+func (f Composed) apply(source, card *CardInstance, s *Stats) {
+	a := &AbilityInstance{Source: source, Controller: source.Controller, Ability: f}
+	for _, e := range f.Effects {
+		subject, ok := e.(CardSubjectAbility)
+		if !ok {
+			continue
+		}
+		m := CardSelf
+		if subject.Match != nil {
+			m = *subject.Match
+		}
+		if !m.Match(a, card) {
+			continue
+		}
+		for _, ef := range subject.Effects {
+			if gets, ok := ef.(Gets); ok {
+				gets.Mods(a).Apply(s)
+			}
+		}
+	}
+}
+
+func (f Composed) HasTarget() bool {
+	for _, e := range f.Effects {
+		if e.HasTarget() {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Composed) IsCost() bool {
+	if f.HasTarget() {
+		return false
+	}
+	for _, e := range f.Effects {
+		if !e.IsCost() {
+			return false
+		}
+	}
+	return true
+}
+
+// Modal lets the controller choose modes when the ability is played, only
+// the effects of the chosen modes happen.
+type Modal struct {
+	Number string `"choose" @("one"|"two"|"three"|Int) "—"`
+	Modes  []Mode `@@ @@+`
+}
+
+type Mode struct {
+	Effects []Effect `"•" @@ (("," ("then"|"and")? | "." (?= ("if"|"otherwise"))) @@ | (?= "unless") @@)* ("." (?= "•"))?`
+	text    string
+}
+
+func (m Mode) Text() string { return m.text }
 
-		var o *Player
-		for _, p := range card.Controller.game.Players {
-			if p != card.Controller {
-				o = p
-			}
+// setModeText gives the modes of the modal effects the text of their lines
+// in the text of the ability.
+func setModeText(effects []Effect, text string) {
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		if line, ok := strings.CutPrefix(strings.TrimSpace(line), "•"); ok {
+			lines = append(lines, strings.TrimSpace(line))
 		}
-		if o != nil {
-			if other := o.board.Slots[card.index]; other != nil {
-				other.TakeDamage(card.stats.Power.Number)
-			} else {
-				o.LoseLife(card.stats.Power.Number)
+	}
+	for _, e := range effects {
+		if m, ok := e.(Modal); ok {
+			for i := range min(len(m.Modes), len(lines)) {
+				m.Modes[i].text = lines[i]
 			}
 		}
 	}
 }
 
-func createEssenceAbility(color string) *Activated {
-	return &Activated{
-		[]AbilityCost{{Cost: &CostType{Deactivate: true}}},
-		Composed{[]Effect{PlayerSubjectAbility{
-			nil, false, []PlayerEffect{Add{[]CostType{{Color: color}}}},
-		}}, ""},
-		fmt.Sprintf("{T}: Add {%s} essence", strings.ToUpper(color)),
+func (f Modal) Count() int {
+	switch f.Number {
+	case "one":
+		return 1
+	case "two":
+		return 2
+	case "three":
+		return 3
 	}
+	n, _ := strconv.Atoi(f.Number)
+	return n
 }
 
-var SEssenseAbility = createEssenceAbility("s")
-var OEssenseAbility = createEssenceAbility("o")
-var CEssenseAbility = createEssenceAbility("c")
-var WEssenseAbility = createEssenceAbility("w")
-
-var AttackAbility = &Activated{
-	[]AbilityCost{{Cost: &CostType{Deactivate: true}}},
-	Composed{[]Effect{CardSubjectAbility{nil, []CardEffect{Attack{}}}}, ""},
-	"Attack",
+func (f Modal) HasTarget() bool {
+	for _, m := range f.Modes {
+		for _, e := range m.Effects {
+			if e.HasTarget() {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-type Ability interface{ Text() string }
-
-type Effect interface {
-	HasTarget() bool
-	IsCost() bool
-	Do(*EffectInstance)
-	Resolve(*EffectInstance)
-}
-type CardEffect interface {
-	HasTarget() bool
-	IsCost() bool
-	Do(*EffectInstance)
-	Resolve(*EffectInstance)
-}
-type PlayerEffect interface {
-	HasTarget() bool
-	IsCost() bool
-	Do(*EffectInstance)
-	Resolve(*EffectInstance)
-}
+func (f Modal) IsCost() bool { return false }
 
-type Keyword struct {
-	Value string `@("fly"|"siege"|"poison"|"ambush")`
+func (f Modal) Do(e *EffectInstance) {
+	a := e.Ability
+	if a.Modes == nil {
+		// Restored and cloned abilities keep the modes chosen before
+		a.Modes = a.Controller.chooseModes(f.Modes, f.Count())
+	}
+	for _, i := range a.Modes {
+		if i < 0 || i >= len(f.Modes) {
+			continue
+		}
+		doEffects(a, f.Modes[i].Effects)
+	}
 }
 
-func (f Keyword) Text() string { return f.Value }
+func (f Modal) Resolve(e *EffectInstance) {}
 
-type AbilityCost struct {
-	Cost   *CostType `@@`
-	Action *Effect   `| @@`
+// Conditional effects only happen if the condition holds when the ability
+// resolves, with "instead" they replace the effect before them.
+type Conditional struct {
+	If      Condition `"if" @@ ","`
+	Effects []Effect  `@@ ("," ("then"|"and")? @@)*`
+	Instead bool      `@"instead"?`
 }
 
-type Composed struct {
-	Effects []Effect `@@ ("," ("then"|"and")? @@)* "."`
-	text    string
+func (f Conditional) HasTarget() bool {
+	for _, e := range f.Effects {
+		if e.HasTarget() {
+			return true
+		}
+	}
+	return false
 }
 
-func (f Composed) Text() string { return f.text }
+func (f Conditional) IsCost() bool              { return false }
+func (f Conditional) Do(e *EffectInstance)      { doEffects(e.Ability, f.Effects) }
+func (f Conditional) Resolve(e *EffectInstance) {}
 
-func (f Composed) Do(p *Player, a *AbilityInstance) {
-	for _, e := range f.Effects {
-		e.Do(&EffectInstance{Ability: a, Effect: e})
-	}
+// Otherwise effects happen if the condition before them does not hold.
+type Otherwise struct {
+	Effects []Effect `"otherwise" ","? @@ ("," ("then"|"and")? @@)*`
 }
 
-func (f Composed) HasTarget() bool {
+func (f Otherwise) HasTarget() bool {
 	for _, e := range f.Effects {
 		if e.HasTarget() {
 			return true
@@ -1556,16 +2893,39 @@ func (f Composed) HasTarget() bool {
 	return false
 }
 
-func (f Composed) IsCost() bool {
-	if f.HasTarget() {
-		return false
+func (f Otherwise) IsCost() bool              { return false }
+func (f Otherwise) Do(e *EffectInstance)      { doEffects(e.Ability, f.Effects) }
+func (f Otherwise) Resolve(e *EffectInstance) {}
+
+// Unless stops the effect before it if the condition holds or one of the
+// players spends the cost when the ability resolves.
+type Unless struct {
+	Player *PlayerMatch `"unless" ( @@ ("spend"|"spends")`
+	Cost   []CostType   `@@+`
+	If     *Condition   `| @@ )`
+}
+
+func (f Unless) HasTarget() bool           { return false }
+func (f Unless) IsCost() bool              { return false }
+func (f Unless) Do(e *EffectInstance)      {}
+func (f Unless) Resolve(e *EffectInstance) {}
+
+func (f Unless) holds(a *AbilityInstance) bool {
+	if f.If != nil {
+		return f.If.Match(a, a.Source)
 	}
-	for _, e := range f.Effects {
-		if !e.IsCost() {
-			return false
+	costs := []AbilityCost{}
+	for i := range f.Cost {
+		costs = append(costs, AbilityCost{Cost: &f.Cost[i]})
+	}
+	for _, o := range a.Controller.game.Query(a, f.Player, nil, -1) {
+		p := o.(*Player)
+		if p.CanPay(a.Source, costs) && p.payUnless(a.Source, f.Cost) {
+			p.Pay(a, costs)
+			return true
 		}
 	}
-	return true
+	return false
 }
 
 type CardSubjectAbility struct {
@@ -1595,13 +2955,21 @@ func (f CardSubjectAbility) Do(e *EffectInstance) {
 	if f.Match != nil {
 		m = *f.Match
 	}
-	cardSubject := a.Controller.game.Query(a, m, nil, -1)
+	var cardSubject []any
+	if !m.HasTarget() {
+		cardSubject = a.Controller.game.Query(a, m, nil, -1)
+	}
 	for _, ef := range f.Effects {
 		effect := EffectInstance{
 			Ability:  a,
 			Effect:   ef,
 			Subjects: cardSubject,
 		}
+		if m.HasTarget() {
+			// The subject is picked when played, effects can match their own objects
+			effect.Match = m
+			effect.Zone = &ZoneMatch{Z: []Zone{ZoneBoard}}
+		}
 		ef.Do(&effect)
 		a.Effects = append(a.Effects, effect)
 	}
@@ -1648,6 +3016,7 @@ func (f PlayerSubjectAbility) Do(e *EffectInstance) {
 			Ability:  a,
 			Effect:   ef,
 			Subjects: playerSubject,
+			Optional: f.Optional,
 		}
 		ef.Do(&effect)
 		a.Effects = append(a.Effects, effect)
@@ -1656,8 +3025,10 @@ func (f PlayerSubjectAbility) Do(e *EffectInstance) {
 
 func (f PlayerSubjectAbility) Resolve(e *EffectInstance) {}
 
+// Activated abilities are told apart from spell effects by the ":" after the
+// costs, so the costs are only parsed when the sentence has one.
 type Activated struct {
-	Cost   []AbilityCost `@@+ ":"`
+	Cost   []AbilityCost `(?= (!(":" | "."))* ":") @@ (","? @@)* ":"`
 	Effect Composed      `@@`
 	text   string
 }
@@ -1674,11 +3045,45 @@ func (f Activated) CanDo(card *CardInstance) bool {
 
 func (f *Activated) Do(p *Player, c *CardInstance) *AbilityInstance {
 	a := NewAbilityInstance(p, c, f)
-	p.Pay(c, f.Cost)
+	a.X = p.Pay(a, f.Cost)
 	f.Effect.Do(p, a)
 	return a
 }
 
+// essence returns the essence the ability adds when it is an essence ability
+// of the card: it only adds essence and costs only activating or deactivating
+// the card, which the card can do right now.
+func (f Activated) essence(card *CardInstance) ([]string, bool) {
+	for _, ct := range f.Cost {
+		if ct.Cost == nil || !(ct.Cost.Activate && !card.activated || ct.Cost.Deactivate && card.activated) {
+			return nil, false
+		}
+	}
+	if len(f.Effect.Effects) != 1 {
+		return nil, false
+	}
+	ps, ok := f.Effect.Effects[0].(PlayerSubjectAbility)
+	if !ok || ps.Match != nil || ps.Optional {
+		return nil, false
+	}
+	essence := []string{}
+	for _, e := range ps.Effects {
+		add, ok := e.(Add)
+		if !ok {
+			return nil, false
+		}
+		for _, c := range add.Value {
+			if c.Color != "" {
+				essence = append(essence, c.Color)
+			}
+			for range c.Number.Number {
+				essence = append(essence, "u")
+			}
+		}
+	}
+	return essence, len(essence) > 0
+}
+
 func (f Activated) IsCost() bool {
 	if f.Effect.HasTarget() {
 		return false
@@ -1733,9 +3138,11 @@ func (c PlayerCondition) Do(p *Player, a *AbilityInstance) {
 }
 
 type CardCondition struct {
-	Cards  CardMatch `@@`
-	Enters bool      `@(("is"|"are") "put" "on" "the" "board")`
-	Leaves bool      `| @(("leave"|"leave") "the" "board")`
+	Cards   CardMatch `@@`
+	Enters  bool      `( @(("is"|"are") "put" "on" "the" "board")`
+	Leaves  bool      `| @(("leave"|"leaves") "the" "board")`
+	Attacks bool      `| @("attack"|"attacks")`
+	Blocks  bool      `| @("block"|"blocks") )`
 }
 
 func (c CardCondition) Match(a *AbilityInstance, o *CardInstance) bool {
@@ -1750,6 +3157,14 @@ func (c CardCondition) Match(a *AbilityInstance, o *CardInstance) bool {
 		if a.Event != EventOnLeaveBoard {
 			return false
 		}
+	} else if c.Attacks {
+		if a.Event != EventOnAttack {
+			return false
+		}
+	} else if c.Blocks {
+		if a.Event != EventOnBlock {
+			return false
+		}
 	}
 	return true
 }
@@ -1761,10 +3176,13 @@ func (c CardCondition) Do(p *Player, a *AbilityInstance) {
 type Condition struct {
 	YourTurn        bool             `@("it's" "your" "turn")`
 	NotYourTurn     bool             `| @("it's" "not" "your" "turn")`
+	Control         *Control         `| @@`
 	PlayerCondition *PlayerCondition `| @@`
 	CardCondition   *CardCondition   `| @@`
 	Number          *Numberical      `| ( @@ "is"`
 	Compare         *Compare         `@@ )`
+	Count           *Count           `| ( @@ "is"`
+	Is              *Compare         `@@ )`
 }
 
 func (c Condition) Match(a *AbilityInstance, o *CardInstance) bool {
@@ -1784,14 +3202,36 @@ func (c Condition) Match(a *AbilityInstance, o *CardInstance) bool {
 		if !c.CardCondition.Match(a, o) {
 			return false
 		}
+	} else if c.Control != nil {
+		if !c.Control.Match(a) {
+			return false
+		}
 	} else if c.Number != nil {
 		if !c.Compare.Compare(a, c.Number.Value(a, o)) {
 			return false
 		}
+	} else if c.Count != nil {
+		if !c.Is.Compare(a, c.Count.Value(a)) {
+			return false
+		}
 	}
 	return true
 }
 
+type Control struct {
+	Player PlayerMatch `@@ ("control"|"controls") ("a"|"an")?`
+	Cards  CardMatch   `@@`
+}
+
+func (c Control) Match(a *AbilityInstance) bool {
+	for _, o := range a.Controller.game.Query(a, c.Cards, &ZoneMatch{Z: []Zone{ZoneBoard}}, -1) {
+		if c.Player.Match(a, o.(*CardInstance).Controller) {
+			return true
+		}
+	}
+	return false
+}
+
 func (c Condition) Do(p *Player, a *AbilityInstance) {
 	if c.PlayerCondition != nil {
 		c.PlayerCondition.Do(p, a)
@@ -1881,6 +3321,7 @@ type Prefix struct {
 	Activated   bool     `| "activated"`
 	Deactivated bool     `| "deactivated"`
 	Stats       *Stats   `| @@`
+	SubType     SubType  `| @@`
 }
 
 func (c Prefix) Match(a *AbilityInstance, card *CardInstance) bool {
@@ -1897,8 +3338,12 @@ func (c Prefix) Match(a *AbilityInstance, card *CardInstance) bool {
 		if hasColor {
 			return false
 		}
+	} else if c.Type.Value != "" {
+		if !card.HasType(string(c.Type.Value)) {
+			return false
+		}
 	} else if c.NonType.Value != "" {
-		if card.HasType(c.NonType.Value) {
+		if card.HasType(string(c.NonType.Value)) {
 			return false
 		}
 	} else if c.Activated {
@@ -1909,6 +3354,10 @@ func (c Prefix) Match(a *AbilityInstance, card *CardInstance) bool {
 		if card.activated {
 			return false
 		}
+	} else if c.SubType.Value != "" {
+		if !card.HasSubType(c.SubType.Value) {
+			return false
+		}
 	} else if c.Stats != nil {
 		if card.Card.Stats.Power.Number != c.Stats.Power.Number ||
 			card.Card.Stats.Health.Number != c.Stats.Health.Number {
@@ -1919,7 +3368,8 @@ func (c Prefix) Match(a *AbilityInstance, card *CardInstance) bool {
 }
 
 type Suffix struct {
-	Targets *CardMatch `"that" "targets" @@`
+	Targets    *CardMatch       `"that" "targets" @@`
+	Controller *PlayerTypeMatch `| @@ ("control"|"controls")`
 }
 
 func (c Suffix) Match(a *AbilityInstance, card *CardInstance) bool {
@@ -1931,6 +3381,9 @@ func (c Suffix) Match(a *AbilityInstance, card *CardInstance) bool {
 		}
 		return false
 	}
+	if c.Controller != nil {
+		return c.Controller.Match(a, card.Controller)
+	}
 	return true
 }
 
@@ -1940,26 +3393,42 @@ type Numberical struct {
 
 func (n Numberical) Value(a *AbilityInstance, c *CardInstance) int {
 	if n.Attribute == "damage" {
-		return c.stats.Power.Number
+		return c.GetPower().Number
 	} else if n.Attribute == "health" {
-		return c.stats.Health.Number
+		return c.GetHealth().Number
 	}
 	panic("Invalid numberical")
 }
 
 type Count struct {
-	Objects    *CardMatch  `"the" "number" "of" @@`
-	Owner      *CardMatch  `| ( @@ "'s" `
-	Numberical *Numberical `@@ )`
-	Number     NumberOrX   `| @@`
+	Counters   *CounterCount `"the" "number" "of" ( @@`
+	Objects    *CardMatch    `| @@`
+	Zone       *ZoneMatch    `("in" @@)? )`
+	Owner      *CardMatch    `| ( @@ "'s" `
+	Numberical *Numberical   `@@ )`
+	Number     NumberOrX     `| @@`
 }
 
 func (c Count) Value(a *AbilityInstance) int {
+	if c.Counters != nil {
+		return c.Counters.Value(a)
+	}
 	if c.Objects != nil {
-		return len(a.Controller.game.Query(a, c.Objects, nil, -1))
+		// Without a zone only the cards on the board are counted
+		zone := c.Zone
+		if zone == nil {
+			zone = &ZoneMatch{Z: []Zone{ZoneBoard}}
+		}
+		n := 0
+		for _, o := range a.Controller.game.Query(a, c.Objects, zone, -1) {
+			if _, ok := o.(*CardInstance); ok {
+				n++
+			}
+		}
+		return n
 	}
 	if c.Owner != nil {
-		o := a.Controller.game.Query(a, c.Objects, nil, 1)
+		o := a.Controller.game.Query(a, c.Owner, nil, 1)
 		if len(o) == 0 {
 			return -1
 		}
@@ -1968,6 +3437,25 @@ func (c Count) Value(a *AbilityInstance) int {
 	return c.Number.Value(a)
 }
 
+type CounterCount struct {
+	Counter *CounterType `( @@ | "counters" )`
+	Objects *CardMatch   `"on" @@`
+}
+
+func (c CounterCount) Value(a *AbilityInstance) int {
+	kind := ""
+	if c.Counter != nil {
+		kind = c.Counter.Kind(a)
+	}
+	n := 0
+	for _, o := range a.Controller.game.Query(a, c.Objects, nil, -1) {
+		if card, ok := o.(*CardInstance); ok {
+			n += card.GetCounters(kind)
+		}
+	}
+	return n
+}
+
 type Compare struct {
 	GreaterThen bool  `(@("greater" "then")`
 	LessThen    bool  `| @("less" "then"))?`
@@ -1990,9 +3478,10 @@ func (c Compare) Compare(a *AbilityInstance, val int) bool {
 }
 
 type With struct {
-	Ability []Keyword   `"with" ( (@@ (("," @@)* "and" @@)?)`
-	Number  *Numberical `| (@@`
-	Compare *Compare    `@@))`
+	Ability []Keyword    `"with" ( (@@ (("," @@)* "and" @@)?)`
+	Number  *Numberical  `| (@@`
+	Compare *Compare     `@@)`
+	Counter *CounterType `| ("a"|"an")? @@ "on" ("it"|"them") )`
 }
 
 func (w With) Match(a *AbilityInstance, o any) bool {
@@ -2003,18 +3492,24 @@ func (w With) Match(a *AbilityInstance, o any) bool {
 				return false
 			}
 		}
+		return true
 	} else if w.Number != nil {
 		value := w.Number.Value(a, card)
 		return w.Compare.Compare(a, value)
+	} else if w.Counter != nil {
+		return card.GetCounters(w.Counter.Kind(a)) > 0
 	}
 	panic("Invalid with")
 }
 
 type CardTypeMatch struct {
 	Self      bool     `@("NAME")`
-	This      bool     `| @("this"|"thas"|"it")`
+	This      bool     `| @("this"|"thas"|"it"|"them")`
 	Sacrifice bool     `| ( ( @("the" "sacrificed")`
-	Target    bool     `| @("target") )?`
+	Target    bool     `| @("target")`
+	Each      bool     `| @("each"|"all")`
+	A         bool     `| @("a"|"an") )?`
+	Other     bool     `@("other")?`
 	Prefix    []Prefix `@@*`
 	Type      CardType `@@? ("card"|"cards")?`
 	Without   *Keyword `("without" @@)?`
@@ -2027,7 +3522,10 @@ func (c CardTypeMatch) Match(a *AbilityInstance, o any) bool {
 	if !ok {
 		return false
 	}
-	if c.Type.Value != "" && !card.HasType(c.Type.Value) {
+	if c.Type.Value != "" && !card.HasType(string(c.Type.Value)) {
+		return false
+	}
+	if c.Other && a.Source == card {
 		return false
 	}
 	if c.Self && a.Source != card {
@@ -2068,7 +3566,7 @@ func (c CardTypeMatch) Match(a *AbilityInstance, o any) bool {
 }
 
 func (c CardTypeMatch) NrTargets(a *AbilityInstance) int {
-	if c.Target {
+	if c.Target || c.A {
 		return 1
 	}
 	return -1
@@ -2088,6 +3586,17 @@ func (c CardMatch) NrTargets(a *AbilityInstance) int {
 	return -1
 }
 
+// This reports whether the match refers to the objects of an earlier effect
+// or the objects sacrificed to pay for the ability.
+func (c CardMatch) This() bool {
+	for _, match := range c.M {
+		if match.This || match.Sacrifice {
+			return true
+		}
+	}
+	return false
+}
+
 func (c CardMatch) HasTarget() bool {
 	for _, match := range c.M {
 		if match.Target {
@@ -2137,8 +3646,13 @@ func (c PlayerTypeMatch) Match(a *AbilityInstance, o any) bool {
 			return false
 		}
 	} else if c.Controller {
-		for _, c := range a.This {
-			if c.(*CardInstance).Controller == p {
+		// The controller of the triggering or else the targeted cards
+		objects := a.This
+		if len(objects) == 0 {
+			objects = a.Targeting
+		}
+		for _, o := range objects {
+			if card, ok := o.(*CardInstance); ok && card.Controller == p {
 				return true
 			}
 		}
@@ -2217,10 +3731,33 @@ func (c AnyMatch) Match(a *AbilityInstance, o any) bool {
 }
 
 type ZoneMatch struct {
-	Z []Zone `@("deck"|"hand"|"board"|"pile"|"stack")+`
+	Your  bool   `( @"your"`
+	Their bool   `| @"their" )?`
+	Z     []Zone `@("deck"|"hand"|"board"|"pile"|"stack")+`
+}
+
+func (z *Zone) Capture(values []string) error {
+	switch values[0] {
+	case "deck":
+		*z = ZoneDeck
+	case "hand":
+		*z = ZoneHand
+	case "board":
+		*z = ZoneBoard
+	case "pile":
+		*z = ZonePile
+	case "stack":
+		*z = ZoneStack
+	default:
+		return fmt.Errorf("invalid zone %q", values[0])
+	}
+	return nil
 }
 
 func (c *ZoneMatch) Match(ability *AbilityInstance, place Zone, player *Player) bool {
+	if ability != nil && (c.Your && player != ability.Controller || c.Their && player == ability.Controller) {
+		return false
+	}
 	for _, zone := range c.Z {
 		if zone == place {
 			return true
@@ -2239,7 +3776,8 @@ func IsIn(o any, l []any) bool {
 }
 
 type Draw struct {
-	Number NumberOrX `("draw"|"draws") (@@ | "a") ("card"|"cards")`
+	Number NumberOrX `("draw"|"draws") @@? ("card"|"cards")`
+	Scale  *Scale    `@@?`
 }
 
 func (f Draw) HasTarget() bool      { return false }
@@ -2247,8 +3785,8 @@ func (f Draw) IsCost() bool         { return false }
 func (f Draw) Do(a *EffectInstance) {}
 func (f Draw) Resolve(e *EffectInstance) {
 	n := 1
-	if f.Number.X || f.Number.Number > 0 {
-		n = f.Number.Value(e.Ability)
+	if f.Number.X || f.Number.Number > 0 || f.Scale != nil {
+		n = f.Scale.Value(e.Ability, f.Number)
 	}
 	for _, p := range e.Subjects {
 		p.(*Player).Draw(n)
@@ -2260,6 +3798,7 @@ type Token struct {
 	Stats    *Stats     `@@?`
 	Types    []CardType `@@*`
 	Subtypes []SubType  `@@* ("token"|"tokens")`
+	Scale    *Scale     `@@?`
 }
 
 func (f Token) HasTarget() bool      { return false }
@@ -2268,7 +3807,7 @@ func (f Token) Do(a *EffectInstance) {}
 func (f Token) Resolve(e *EffectInstance) {
 	types := append(f.Types, CardType{"token"})
 	c := &Card{Types: types, Subtypes: f.Subtypes, Stats: f.Stats}
-	n := f.Number.Value(e.Ability)
+	n := f.Scale.Value(e.Ability, f.Number)
 	for _, p := range e.Subjects {
 		for i := 0; i < n; i++ {
 			p.(*Player).Place(
@@ -2288,7 +3827,7 @@ func (f Destroy) HasTarget() bool { return f.Value.HasTarget() }
 func (f Destroy) IsCost() bool    { return false }
 func (f Destroy) Do(a *EffectInstance) {
 	a.Match = f.Value
-	a.Zone = &ZoneMatch{[]Zone{ZoneBoard}}
+	a.Zone = &ZoneMatch{Z: []Zone{ZoneBoard}}
 }
 func (f Destroy) Resolve(e *EffectInstance) {
 	for _, c := range e.matches {
@@ -2321,7 +3860,8 @@ func (f Add) Resolve(e *EffectInstance) {
 }
 
 type GainLife struct {
-	Value NumberOrX `("gain"|"gains") @@ "life"`
+	Value NumberOrX `("gain"|"gains") @@? "life"`
+	Scale *Scale    `@@?`
 }
 
 func (f GainLife) HasTarget() bool      { return false }
@@ -2329,12 +3869,13 @@ func (f GainLife) IsCost() bool         { return false }
 func (f GainLife) Do(a *EffectInstance) {}
 func (f GainLife) Resolve(e *EffectInstance) {
 	for _, p := range e.Subjects {
-		p.(*Player).GainLife(f.Value.Value(e.Ability))
+		p.(*Player).GainLife(f.Scale.Value(e.Ability, f.Value))
 	}
 }
 
 type LoseLife struct {
-	Value NumberOrX `("lose"|"loses") @@ "life"`
+	Value NumberOrX `("lose"|"loses") @@? "life"`
+	Scale *Scale    `@@?`
 }
 
 func (f LoseLife) HasTarget() bool      { return false }
@@ -2342,23 +3883,64 @@ func (f LoseLife) IsCost() bool         { return false }
 func (f LoseLife) Do(a *EffectInstance) {}
 func (f LoseLife) Resolve(e *EffectInstance) {
 	for _, p := range e.Subjects {
-		p.(*Player).LoseLife(f.Value.Value(e.Ability))
+		p.(*Player).LoseLife(f.Scale.Value(e.Ability, f.Value))
+	}
+}
+
+type Heal struct {
+	All     bool       `("heal"|"heals") ( @"all"`
+	Number  NumberOrX  `| @@ ) "damage" "from"`
+	Objects *CardMatch `@@`
+}
+
+func (f Heal) HasTarget() bool { return f.Objects.HasTarget() }
+func (f Heal) IsCost() bool    { return false }
+func (f Heal) Do(a *EffectInstance) {
+	a.Match = f.Objects
+	a.Zone = &ZoneMatch{Z: []Zone{ZoneBoard}}
+}
+func (f Heal) Resolve(e *EffectInstance) {
+	n := -1
+	if !f.All {
+		n = f.Number.Value(e.Ability)
+	}
+	for _, c := range e.matches {
+		c.(*CardInstance).Heal(n)
+	}
+}
+
+type Prevent struct {
+	Number  NumberOrX  `("prevent"|"prevents") "the" "next" @@ "damage"`
+	Objects *CardMatch `("that" "would" "be" "dealt")? "to" @@`
+}
+
+func (f Prevent) HasTarget() bool { return f.Objects.HasTarget() }
+func (f Prevent) IsCost() bool    { return false }
+func (f Prevent) Do(a *EffectInstance) {
+	a.Match = f.Objects
+	a.Zone = &ZoneMatch{Z: []Zone{ZoneBoard}}
+}
+func (f Prevent) Resolve(e *EffectInstance) {
+	n := f.Number.Value(e.Ability)
+	for _, c := range e.matches {
+		c.(*CardInstance).PreventDamage(n)
 	}
 }
 
 type Discard struct {
 	Number NumberOrX  `("discard"|"discards") @@`
 	Value  *CardMatch `@@?`
+	Scale  *Scale     `@@?`
 }
 
 func (f Discard) HasTarget() bool { return f.Value.HasTarget() }
 func (f Discard) IsCost() bool    { return false }
 func (f Discard) Do(a *EffectInstance) {
 	a.Match = f.Value
-	a.Zone = &ZoneMatch{[]Zone{ZoneHand}}
+	a.Zone = &ZoneMatch{Z: []Zone{ZoneHand}}
 }
 func (f Discard) Resolve(e *EffectInstance) {
-	n := f.Number.Value(e.Ability)
+	n := f.Scale.Value(e.Ability, f.Number)
 	for _, p := range e.Subjects {
 		choices := []int{}
 		if !p.(*Player).prompt("discard", n, e.matches, &choices) {
@@ -2382,7 +3964,7 @@ func (f Shuffle) IsCost() bool         { return false }
 func (f Shuffle) Do(a *EffectInstance) { a.Match = f.Objects; a.Zone = f.Value }
 func (f Shuffle) Resolve(e *EffectInstance) {
 	// TODO: handle multiple zones?
-	zone := e.zones[0]
+	zone := f.Value.Z[0]
 	for _, c := range e.matches {
 		card := c.(*CardInstance)
 		card.Owner.Place(card, zone, -1)
@@ -2415,31 +3997,129 @@ func (f Look) IsCost() bool         { return false }
 func (f Look) Do(a *EffectInstance) { a.Zone = f.Zone }
 func (f Look) Resolve(e *EffectInstance) {
 	n := f.Number.Value(e.Ability)
+	e.Ability.This = []any{}
 	for _, p := range e.Subjects {
 		cards := p.(*Player).Query(e.Ability, nil, f.Zone)
-		p.(*Player).prompt("look", n, cards[:n], nil)
+		cards = cards[:min(n, len(cards))]
+		// Later effects of the ability refer to the cards with "them"
+		e.Ability.This = append(e.Ability.This, cards...)
+		selected := []int{}
+		if !p.(*Player).prompt("look", len(cards), cards, &selected) {
+			return
+		}
+	}
+}
+
+// CounterType is the kind of a counter, +X/+Y counters change the stats of
+// the card they are on.
+type CounterType struct {
+	Pplus  bool      `( @("+"|"-")`
+	Power  NumberOrX `@@ "/"`
+	Hplus  bool      `@("+"|"-")`
+	Health NumberOrX `@@`
+	Name   string    `| @Ident ) ("counter"|"counters")`
+}
+
+func (c CounterType) Kind(a *AbilityInstance) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	m := Gets{Pplus: c.Pplus, Power: c.Power, Hplus: c.Hplus, Health: c.Health}.Mods(a)
+	return fmt.Sprintf("%+d/%+d", m.Power, m.Health)
+}
+
+type PutCounter struct {
+	Number  NumberOrX   `("put"|"puts") @@`
+	Counter CounterType `@@ "on"`
+	Objects *CardMatch  `@@`
+	Scale   *Scale      `@@?`
+}
+
+func (f PutCounter) HasTarget() bool { return f.Objects.HasTarget() }
+func (f PutCounter) IsCost() bool    { return false }
+func (f PutCounter) Do(a *EffectInstance) {
+	a.Match = f.Objects
+	a.Zone = &ZoneMatch{Z: []Zone{ZoneBoard}}
+}
+func (f PutCounter) Resolve(e *EffectInstance) {
+	n := f.Scale.Value(e.Ability, f.Number)
+	for _, c := range e.matches {
+		c.(*CardInstance).AddCounter(f.Counter.Kind(e.Ability), n)
+	}
+}
+
+type RemoveCounter struct {
+	All     bool         `("remove"|"removes") ( @"all"`
+	Number  NumberOrX    `| @@ )`
+	Counter *CounterType `( @@ | "counters" )`
+	Objects *CardMatch   `"from" @@`
+}
+
+func (f RemoveCounter) HasTarget() bool { return f.Objects.HasTarget() }
+func (f RemoveCounter) IsCost() bool    { return false }
+func (f RemoveCounter) Do(a *EffectInstance) {
+	a.Match = f.Objects
+	a.Zone = &ZoneMatch{Z: []Zone{ZoneBoard}}
+}
+func (f RemoveCounter) Resolve(e *EffectInstance) {
+	n := -1
+	if !f.All {
+		n = f.Number.Value(e.Ability)
+	}
+	for _, c := range e.matches {
+		card := c.(*CardInstance)
+		if f.Counter != nil {
+			card.RemoveCounter(f.Counter.Kind(e.Ability), n)
+			continue
+		}
+		for _, kind := range slices.Sorted(maps.Keys(card.counters)) {
+			card.RemoveCounter(kind, n)
+		}
 	}
 }
 
 type Put struct {
 	Objects     *CardMatch `("put"|"puts") @@`
-	Top         bool       `( @"on top of"`
-	Bottom      bool       `| @"on the bottom of"`
+	From        *ZoneMatch `("from" @@)?`
+	Top         bool       `( @("on" "top" "of")`
+	Bottom      bool       `| @("on" "the" "bottom" "of")`
 	Zone        *ZoneMatch `| "into") @@`
-	Ordered     bool       `"in any order"?`
-	Random      bool       `"in random order"?`
+	Ordered     bool       `( @("in" "any" "order")`
+	Random      bool       `| @("in" "a"? "random" "order") )?`
 	Deactivated bool       `@("deactivated")?`
 }
 
-func (f Put) HasTarget() bool      { return f.Objects.HasTarget() }
-func (f Put) IsCost() bool         { return false }
-func (f Put) Do(a *EffectInstance) { a.Match = f.Objects; a.Zone = f.Zone }
+func (f Put) HasTarget() bool { return f.Objects.HasTarget() }
+func (f Put) IsCost() bool    { return false }
+func (f Put) Do(a *EffectInstance) {
+	a.Match = f.Objects
+	if f.From != nil {
+		a.Zone = f.From
+	} else if !f.Objects.This() {
+		a.Zone = &ZoneMatch{Z: []Zone{ZoneBoard}}
+	}
+}
 func (f Put) Resolve(e *EffectInstance) {
-	// TODO: handle ordering and top/bottom
-	zone := e.zones[0]
-	for _, c := range e.matches {
+	zone := f.Zone.Z[0]
+	cards := slices.Clone(e.matches)
+	if f.Random {
+		e.Ability.Controller.game.rng.Shuffle(len(cards), func(i, j int) {
+			cards[i], cards[j] = cards[j], cards[i]
+		})
+	} else if f.Ordered && len(cards) > 1 {
+		cards = e.Ability.Controller.order(cards)
+	}
+	if f.Top {
+		// The first card ends up on top
+		slices.Reverse(cards)
+	}
+	for _, c := range cards {
 		card := c.(*CardInstance)
-		card.Owner.Place(card, zone, -1)
+		index := -1
+		if f.Top {
+			index = 0
+		}
+		card.Owner.Place(card, zone, index)
 		if f.Deactivated {
 			card.Deactivate()
 		}
@@ -2454,7 +4134,7 @@ func (f Activate) HasTarget() bool { return f.Objects.HasTarget() }
 func (f Activate) IsCost() bool    { return false }
 func (f Activate) Do(a *EffectInstance) {
 	a.Match = f.Objects
-	a.Zone = &ZoneMatch{[]Zone{ZoneBoard}}
+	a.Zone = &ZoneMatch{Z: []Zone{ZoneBoard}}
 }
 func (f Activate) Resolve(e *EffectInstance) {
 	for _, c := range e.matches {
@@ -2470,7 +4150,7 @@ func (f Deactivate) HasTarget() bool { return f.Objects.HasTarget() }
 func (f Deactivate) IsCost() bool    { return false }
 func (f Deactivate) Do(a *EffectInstance) {
 	a.Match = f.Objects
-	a.Zone = &ZoneMatch{[]Zone{ZoneBoard}}
+	a.Zone = &ZoneMatch{Z: []Zone{ZoneBoard}}
 }
 func (f Deactivate) Resolve(e *EffectInstance) {
 	for _, c := range e.matches {
@@ -2486,13 +4166,14 @@ func (f Sacrifice) HasTarget() bool { return f.Objects.HasTarget() }
 func (f Sacrifice) IsCost() bool    { return false }
 func (f Sacrifice) Do(a *EffectInstance) {
 	a.Match = f.Objects
-	a.Zone = &ZoneMatch{[]Zone{ZoneBoard}}
+	a.Zone = &ZoneMatch{Z: []Zone{ZoneBoard}}
 }
 func (f Sacrifice) Resolve(e *EffectInstance) {
 	for _, c := range e.matches {
 		card := c.(*CardInstance)
 		card.Owner.Place(card, ZonePile, -1)
 		card.Owner.Emit(EventOnSacrifice, card)
+		e.Ability.Sacrificed = append(e.Ability.Sacrificed, card)
 	}
 }
 
@@ -2525,51 +4206,62 @@ func (f PayLife) Resolve(e *EffectInstance) {
 }
 
 type Damage struct {
-	Number  NumberOrX `("deal"|"deals") @@ "damage" "to"`
+	Number  NumberOrX `("deal"|"deals") @@? "damage" "to"`
 	Objects Match     `@@`
+	Scale   *Scale    `@@?`
 }
 
 func (f Damage) HasTarget() bool { return f.Objects.HasTarget() }
 func (f Damage) IsCost() bool    { return false }
 func (f Damage) Do(a *EffectInstance) {
 	a.Match = f.Objects
-	a.Zone = &ZoneMatch{[]Zone{ZoneBoard}}
+	a.Zone = &ZoneMatch{Z: []Zone{ZoneBoard}}
 }
 func (f Damage) Resolve(e *EffectInstance) {
-	n := f.Number.Value(e.Ability)
+	n := f.Scale.Value(e.Ability, f.Number)
 	for _, c := range e.matches {
-		if card, ok := c.(*CardInstance); ok {
-			card.TakeDamage(n)
-		} else {
-			c.(*Player).LoseLife(n)
-			c.(*Player).Emit(EventOnPlayerDamage, n)
-		}
+		e.Ability.Source.DealDamage(c, n)
 	}
 }
 
 type Gets struct {
-	Pplus  bool      `("get"|"gets") @("+"|"-")`
-	Power  NumberOrX `@@ "/"`
-	Hplus  bool      `@("+"|"-")`
-	Health NumberOrX `@@`
+	Pplus    bool      `("get"|"gets") @("+"|"-")`
+	Power    NumberOrX `@@ "/"`
+	Hplus    bool      `@("+"|"-")`
+	Health   NumberOrX `@@`
+	Duration *Duration `@@?`
+	Scale    *Scale    `@@?`
 }
 
 func (f Gets) HasTarget() bool      { return false }
 func (f Gets) IsCost() bool         { return false }
 func (f Gets) Do(a *EffectInstance) {}
 func (f Gets) Resolve(e *EffectInstance) {
-	// TODO: add duration
-	m := Mods{f.Power.Value(e.Ability), f.Health.Value(e.Ability)}
+	m := f.Mods(e.Ability)
+	if f.Duration != nil {
+		f.Duration.Expire(e.Ability, &m)
+	}
+	subjects := e.Subjects
+	if e.Match != nil {
+		// Targeted subjects are picked when the ability is played
+		subjects = e.matches
+	}
+	for _, c := range subjects {
+		if card, ok := c.(*CardInstance); ok {
+			card.modifier = append(card.modifier, m)
+		}
+	}
+}
+
+func (f Gets) Mods(a *AbilityInstance) Mods {
+	m := Mods{Power: f.Scale.Value(a, f.Power), Health: f.Scale.Value(a, f.Health)}
 	if !f.Pplus {
 		m.Power *= -1
 	}
 	if !f.Hplus {
 		m.Health *= -1
 	}
-	for _, c := range e.Subjects {
-		card := c.(*CardInstance)
-		card.modifier = append(card.modifier, m)
-	}
+	return m
 }
 
 func (b NumberOrX) Format(f fmt.State, c rune) {
@@ -2581,7 +4273,7 @@ func (b NumberOrX) Format(f fmt.State, c rune) {
 }
 func (b CostType) Format(f fmt.State, c rune) {
 	if b.Color != "" {
-		fmt.Fprintf(f, "{%s}", b.Color)
+		fmt.Fprintf(f, "{%s}", strings.Join(append([]string{b.Color}, b.Hybrid...), "/"))
 	} else if b.Activate {
 		fmt.Fprintf(f, "{Q}")
 	} else if b.Deactivate {
@@ -2606,3 +4298,112 @@ func (b Stats) Format(f fmt.State, c rune) {
 func (a AbilityInstance) Format(f fmt.State, c rune) {
 	fmt.Fprintf(f, "%v", a.Source)
 }
+
+// StackMatch matches the cards with items on the stack. Spells are cards
+// being cast, abilities are the activated and triggered abilities of cards.
+type StackMatch struct {
+	Spell   bool `"target" ( @("spell"|"spells")`
+	Ability bool `| @("ability"|"abilities") )`
+}
+
+func (m StackMatch) Match(a *AbilityInstance, o any) bool {
+	item, ok := o.(*AbilityInstance)
+	return ok && (item.Ability == nil) == m.Spell && slices.Contains(a.Controller.game.stack.cards, item)
+}
+
+func (m StackMatch) HasTarget() bool                  { return true }
+func (m StackMatch) NrTargets(a *AbilityInstance) int { return 1 }
+
+// items returns the targeted items that are still on the stack.
+func (m StackMatch) items(e *EffectInstance) []*AbilityInstance {
+	items := []*AbilityInstance{}
+	for _, o := range e.matches {
+		if item, ok := o.(*AbilityInstance); ok && slices.Contains(e.Ability.Controller.game.stack.cards, item) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+type CounterSpell struct {
+	Objects StackMatch `("counter"|"counters") @@`
+}
+
+func (f CounterSpell) HasTarget() bool { return true }
+func (f CounterSpell) IsCost() bool    { return false }
+func (f CounterSpell) Do(a *EffectInstance) {
+	a.Match = f.Objects
+	a.Zone = &ZoneMatch{Z: []Zone{ZoneStack}}
+}
+func (f CounterSpell) Resolve(e *EffectInstance) {
+	g := e.Ability.Controller.game
+	for _, item := range f.Objects.items(e) {
+		g.stack.Remove(item)
+		if item.Ability == nil && !item.Copy {
+			// A countered spell goes to the pile without resolving
+			item.Source.Owner.Place(item.Source, ZonePile, -1)
+		}
+		e.Ability.Controller.Emit(EventOnCountered, item.Source, item)
+	}
+}
+
+type ReturnSpell struct {
+	Objects StackMatch `("return"|"returns") @@ "to" ("its"|"their") "owner" "'s" "hand"`
+}
+
+func (f ReturnSpell) HasTarget() bool { return true }
+func (f ReturnSpell) IsCost() bool    { return false }
+func (f ReturnSpell) Do(a *EffectInstance) {
+	a.Match = f.Objects
+	a.Zone = &ZoneMatch{Z: []Zone{ZoneStack}}
+}
+func (f ReturnSpell) Resolve(e *EffectInstance) {
+	g := e.Ability.Controller.game
+	for _, item := range f.Objects.items(e) {
+		g.stack.Remove(item)
+		if item.Ability == nil && !item.Copy {
+			item.Source.Owner.Place(item.Source, ZoneHand, -1)
+		}
+	}
+}
+
+type CopySpell struct {
+	Objects StackMatch `("copy"|"copies") @@`
+}
+
+func (f CopySpell) HasTarget() bool { return true }
+func (f CopySpell) IsCost() bool    { return false }
+func (f CopySpell) Do(a *EffectInstance) {
+	a.Match = f.Objects
+	a.Zone = &ZoneMatch{Z: []Zone{ZoneStack}}
+}
+func (f CopySpell) Resolve(e *EffectInstance) {
+	for _, item := range f.Objects.items(e) {
+		// The copy is played so its controller chooses new targets
+		e.Ability.Controller.game.Play(item.copy(e.Ability.Controller))
+	}
+}
+
+// copy returns a copy of the stack item controlled by p. The effects are
+// done again, modes and X stay the same.
+func (a *AbilityInstance) copy(p *Player) *AbilityInstance {
+	c := NewAbilityInstance(p, a.Source, a.Ability)
+	c.Copy = true
+	c.Field = a.Field
+	c.X = a.X
+	c.Modes = slices.Clone(a.Modes)
+	c.Event = a.Event
+	c.This = slices.Clone(a.This)
+	c.Sacrificed = slices.Clone(a.Sacrificed)
+	switch ab := a.Ability.(type) {
+	case *Activated:
+		ab.Effect.Do(p, c)
+	case Triggered:
+		ab.Effect.Do(p, c)
+	default:
+		if a.Source.IsSpell() {
+			a.Source.spell(c)
+		}
+	}
+	return c
+}
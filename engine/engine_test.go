@@ -1,8 +1,15 @@
 package engine
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 var TargetCard = CardMatch{[]CardTypeMatch{{Target: true}}}
@@ -148,6 +155,7 @@ func TestCardParser(t *testing.T) {
 										Damage{
 											NumberOrX{Number: 1},
 											AnyMatch{AnyTarget: true},
+											nil,
 										},
 									},
 								},
@@ -184,7 +192,7 @@ func TestCardParser(t *testing.T) {
 							PlayerSubjectAbility{
 								nil, false,
 								[]PlayerEffect{
-									Draw{NumberOrX{Number: 4}},
+									Draw{NumberOrX{Number: 4}, nil},
 								},
 							},
 						}, "",
@@ -200,7 +208,7 @@ func TestCardParser(t *testing.T) {
 												{
 													Prefix: []Prefix{
 														{NonColor: Color{"cup"}},
-														{Type: CardType{"units"}},
+														{Type: CardType{"unit"}},
 													},
 												},
 											},
@@ -240,7 +248,7 @@ func TestCardParser(t *testing.T) {
 							PlayerSubjectAbility{
 								nil, false,
 								[]PlayerEffect{
-									Draw{NumberOrX{A: true}},
+									Draw{NumberOrX{A: true}, nil},
 								},
 							},
 						}, ""},
@@ -264,14 +272,10 @@ func TestCardParser(t *testing.T) {
 }
 
 func newGame(players []*Player) *GameState {
-	g := &GameState{
-		Players: players,
-		stack:   Stack{cards: []*AbilityInstance{}},
+	for i, p := range players {
+		p.Id = i + 1
 	}
-	for _, player := range g.Players {
-		player.game = g
-	}
-	return g
+	return NewGame(0, players...)
 }
 
 func newPlayer(
@@ -280,13 +284,17 @@ func newPlayer(
 	hand []*Card,
 	pile []*Card,
 ) *Player {
+	// Cards need a game to allocate ids from until newGame adopts the player.
 	p := &Player{
+		game:    &GameState{},
 		life:    10,
 		deck:    Pile{Cards: []*CardInstance{}},
 		hand:    Pile{Cards: []*CardInstance{}},
 		pile:    Pile{Cards: []*CardInstance{}},
 		board:   Board{Slots: make([]*CardInstance, boardSize)},
 		essence: []string{},
+		decider: ChannelDecider{},
+		msgChan: make(chan Msg, 1),
 	}
 	for _, card := range deck {
 		p.deck.Add(NewCardInstance(card, p, ZoneDeck))
@@ -295,7 +303,7 @@ func newPlayer(
 		p.hand.Add(NewCardInstance(card, p, ZoneHand))
 	}
 	for _, card := range pile {
-		p.hand.Add(NewCardInstance(card, p, ZoneHand))
+		p.pile.Add(NewCardInstance(card, p, ZonePile))
 	}
 	for i, card := range board {
 		if card != nil {
@@ -313,6 +321,31 @@ func newSimpleUnit(name string) *Card {
 	}
 }
 
+// parseCards parses the card texts in order and fails the test if any of them
+// doesn't parse.
+func parseCards(t *testing.T, txts ...string) []*Card {
+	t.Helper()
+	parser := NewCardParser()
+	cards := []*Card{}
+	for _, txt := range txts {
+		card, err := parser.Parse(txt, true)
+		if err != nil {
+			t.Fatalf("Error parsing card: %v", err)
+		}
+		cards = append(cards, card)
+	}
+	return cards
+}
+
+// newPlayGame starts a game in the play phase of the first turn of the first
+// player, who has priority.
+func newPlayGame(players ...*Player) *GameState {
+	game := newGame(players)
+	game.turn = &Turn{game, players[0], nil, 1, 0}
+	game.turn.phase = &Phase{game.turn, players[0], PhasePlay}
+	return game
+}
+
 func TestGamePhases(t *testing.T) {
 	p1 := newPlayer(
 		[]*Card{newSimpleUnit("card1")},
@@ -320,7 +353,7 @@ func TestGamePhases(t *testing.T) {
 		[]*Card{},
 		[]*Card{},
 	)
-	i := 0
+	i := 1
 	game := newGame([]*Player{p1})
 	game.turn = &Turn{game, p1, nil, 0, 0}
 	game.turn.Iter()(func(phase *Phase) bool {
@@ -394,7 +427,7 @@ func TestTrigger(t *testing.T) {
 						PlayerSubjectAbility{
 							nil, false,
 							[]PlayerEffect{
-								Draw{NumberOrX{}},
+								Draw{NumberOrX{}, nil},
 							},
 						},
 					},
@@ -433,3 +466,1457 @@ func TestTrigger(t *testing.T) {
 		t.Fatalf("Hand size not 1")
 	}
 }
+
+func TestSeededShuffle(t *testing.T) {
+	deck := []*Card{}
+	for i := 0; i < 20; i++ {
+		deck = append(deck, &Card{ID: CardID(i), Name: "card", Types: []CardType{{"unit"}}})
+	}
+	order := func(seed int64) []CardID {
+		game := NewGame(seed)
+		p := game.AddPlayer(deck...)
+		p.Shuffle(ZoneDeck)
+		ids := []CardID{}
+		for _, card := range p.deck.Cards {
+			ids = append(ids, card.Card.ID)
+		}
+		return ids
+	}
+	if !reflect.DeepEqual(order(42), order(42)) {
+		t.Fatalf("Same seed produced different shuffles")
+	}
+	if reflect.DeepEqual(order(42), order(43)) {
+		t.Fatalf("Different seeds produced the same shuffle")
+	}
+}
+
+func randomChoice(rng *rand.Rand, event EventType, choices []any) Msg {
+	if event == EventPromptSource {
+		return Msg{Selected: []int{rng.Intn(2)}}
+	} else if len(choices) == 0 || rng.Intn(4) == 0 {
+		return Msg{Selected: []int{SkipCode}}
+	}
+	return Msg{Selected: []int{rng.Intn(len(choices))}}
+}
+
+// randomBot answers prompts for every player with random choices and fails
+// the prompt after the given number of answers so the game ends.
+func randomBot(g *GameState, seed int64, answers int) {
+	rng := rand.New(rand.NewSource(seed))
+	g.On(AllEvents, func(e *Event) {
+		if e.Event < EventPromptCard {
+			return
+		}
+		if answers <= 0 {
+			e.Player.Send(Msg{Err: errors.New("done")})
+			return
+		}
+		answers -= 1
+		e.Player.Send(randomChoice(rng, e.Event, e.Args[1:]))
+	})
+}
+
+// randomDecider is randomBot as a decider that answers directly.
+type randomDecider struct {
+	rng     *rand.Rand
+	answers int
+}
+
+func newRandomDecider(seed int64, answers int) *randomDecider {
+	return &randomDecider{rand.New(rand.NewSource(seed)), answers}
+}
+
+func (d *randomDecider) choose(event EventType, choices []any) (int, error) {
+	if d.answers <= 0 {
+		return ErrorCode, errors.New("done")
+	}
+	d.answers -= 1
+	return randomChoice(d.rng, event, choices).Selected[0], nil
+}
+
+func (d *randomDecider) ChooseCard(p *Player, choices []any) (int, error) {
+	return d.choose(EventPromptCard, choices)
+}
+
+func (d *randomDecider) ChooseAbility(p *Player, choices []any) (int, error) {
+	return d.choose(EventPromptAbility, choices)
+}
+
+func (d *randomDecider) ChooseField(p *Player, choices []any) (int, error) {
+	return d.choose(EventPromptField, choices)
+}
+
+func (d *randomDecider) ChooseTarget(p *Player, choices []any) (int, error) {
+	return d.choose(EventPromptTarget, choices)
+}
+
+func (d *randomDecider) ChooseDiscard(p *Player, n int, choices []any) ([]int, error) {
+	if _, err := d.choose(EventPromptDiscard, choices); err != nil {
+		return nil, err
+	}
+	return d.rng.Perm(len(choices))[:min(n, len(choices))], nil
+}
+
+func (d *randomDecider) ChooseSacrifice(p *Player, n int, choices []any) ([]int, error) {
+	if _, err := d.choose(EventPromptSacrifice, choices); err != nil {
+		return nil, err
+	}
+	return d.rng.Perm(len(choices))[:min(n, len(choices))], nil
+}
+
+func (d *randomDecider) ChooseSource(p *Player) (int, error) {
+	return d.choose(EventPromptSource, nil)
+}
+
+func (d *randomDecider) ChooseBlock(p *Player, choices []any) ([]int, error) {
+	choice, err := d.choose(EventPromptBlock, choices)
+	return []int{choice}, err
+}
+
+func (d *randomDecider) Confirm(p *Player, card *CardInstance) (bool, error) {
+	choice, err := d.choose(EventPromptConfirm, []any{card})
+	return choice == 0, err
+}
+
+func (d *randomDecider) ConfirmPay(p *Player, card *CardInstance, costs []CostType) (bool, error) {
+	choice, err := d.choose(EventPromptUnless, []any{card})
+	return choice == 0, err
+}
+
+func (d *randomDecider) ChooseModes(p *Player, n int, modes []any) ([]int, error) {
+	if _, err := d.choose(EventPromptMode, modes); err != nil {
+		return nil, err
+	}
+	return d.rng.Perm(len(modes))[:min(n, len(modes))], nil
+}
+
+func (d *randomDecider) ChooseX(p *Player, choices []any) (int, error) {
+	return d.choose(EventPromptX, choices)
+}
+
+func (d *randomDecider) ChooseEssence(p *Player, choices []any) (int, error) {
+	return d.choose(EventPromptPay, choices)
+}
+
+func (d *randomDecider) Look(p *Player, cards []any) error {
+	_, err := d.choose(EventPromptLook, nil)
+	return err
+}
+
+func (d *randomDecider) ChooseOrder(p *Player, cards []any) ([]int, error) {
+	if _, err := d.choose(EventPromptOrder, cards); err != nil {
+		return nil, err
+	}
+	return d.rng.Perm(len(cards)), nil
+}
+
+func gameFingerprint(g *GameState) []int {
+	state := []int{g.turn.turn}
+	for _, p := range g.Players {
+		state = append(state, p.life, len(p.deck.Cards), len(p.pile.Cards))
+		for _, card := range p.hand.Cards {
+			state = append(state, card.ID)
+		}
+		for _, card := range p.board.Slots {
+			if card != nil {
+				state = append(state, card.ID)
+			}
+		}
+	}
+	return state
+}
+
+func TestReplay(t *testing.T) {
+	deck := []*Card{}
+	for i := 0; i < 15; i++ {
+		deck = append(deck, &Card{
+			ID:    CardID(i),
+			Name:  "card",
+			Types: []CardType{{"unit"}},
+			Stats: &Stats{One, Two},
+		})
+	}
+	game := NewGame(7)
+	game.AddPlayer(deck...)
+	game.AddPlayer(deck...)
+	replay := game.Record()
+	randomBot(game, 3, 60)
+	game.Run()
+
+	buf := &bytes.Buffer{}
+	if err := replay.Write(buf); err != nil {
+		t.Fatalf("Error writing replay: %v", err)
+	}
+	loaded, err := ReadReplay(buf)
+	if err != nil {
+		t.Fatalf("Error reading replay: %v", err)
+	}
+	replayed, err := loaded.Load(func(id CardID) *Card { return deck[id] })
+	if err != nil {
+		t.Fatalf("Error loading replay: %v", err)
+	}
+	replayed.Run()
+
+	if !reflect.DeepEqual(gameFingerprint(game), gameFingerprint(replayed)) {
+		t.Fatalf("Replayed game differs: %v != %v", gameFingerprint(game), gameFingerprint(replayed))
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	deck := []*Card{}
+	for i := 0; i < 15; i++ {
+		deck = append(deck, &Card{
+			ID:    CardID(i),
+			Name:  "card",
+			Types: []CardType{{"unit"}},
+			Stats: &Stats{One, Two},
+		})
+	}
+	lookup := func(id CardID) *Card { return deck[id] }
+	game := NewGame(11)
+	game.AddPlayer(deck...)
+	game.AddPlayer(deck...)
+	randomBot(game, 5, 40)
+	game.Run()
+
+	buf := &bytes.Buffer{}
+	if err := game.Snapshot().Write(buf); err != nil {
+		t.Fatalf("Error writing snapshot: %v", err)
+	}
+	saved := buf.String()
+	restore := func() *GameState {
+		s, err := ReadSnapshot(bytes.NewBufferString(saved))
+		if err != nil {
+			t.Fatalf("Error reading snapshot: %v", err)
+		}
+		g, err := Restore(s, lookup)
+		if err != nil {
+			t.Fatalf("Error restoring snapshot: %v", err)
+		}
+		return g
+	}
+
+	restored := restore()
+	buf.Reset()
+	if err := restored.Snapshot().Write(buf); err != nil {
+		t.Fatalf("Error writing snapshot: %v", err)
+	}
+	if buf.String() != saved {
+		t.Fatalf("Restored game does not match snapshot:\n%s\n%s", saved, buf.String())
+	}
+
+	// Both copies continue from the saved position in the same way.
+	other := restore()
+	randomBot(restored, 9, 40)
+	randomBot(other, 9, 40)
+	restored.Run()
+	other.Run()
+	if !reflect.DeepEqual(gameFingerprint(restored), gameFingerprint(other)) {
+		t.Fatalf("Restored games diverged: %v != %v", gameFingerprint(restored), gameFingerprint(other))
+	}
+	if reflect.DeepEqual(gameFingerprint(restored), gameFingerprint(game)) {
+		t.Fatalf("Restored game did not continue")
+	}
+}
+
+func TestClone(t *testing.T) {
+	deck := []*Card{}
+	for i := 0; i < 15; i++ {
+		deck = append(deck, &Card{
+			ID:    CardID(i),
+			Name:  "card",
+			Types: []CardType{{"unit"}},
+			Stats: &Stats{One, Two},
+		})
+	}
+	game := NewGame(5)
+	game.AddPlayer(deck...).SetDecider(newRandomDecider(1, 30))
+	game.AddPlayer(deck...).SetDecider(newRandomDecider(2, 30))
+	game.Run()
+
+	snapshot := func(g *GameState) string {
+		buf := &bytes.Buffer{}
+		if err := g.Snapshot().Write(buf); err != nil {
+			t.Fatalf("Error writing snapshot: %v", err)
+		}
+		return buf.String()
+	}
+	before := snapshot(game)
+	clone := game.Clone()
+	if snapshot(clone) != before {
+		t.Fatalf("Clone does not match the game")
+	}
+	for i, p := range clone.Players {
+		p.SetDecider(newRandomDecider(int64(i+3), 30))
+	}
+	clone.Run()
+	if snapshot(game) != before {
+		t.Fatalf("Running the clone changed the game")
+	}
+	if snapshot(clone) == before {
+		t.Fatalf("Clone did not continue")
+	}
+}
+
+func BenchmarkClone(b *testing.B) {
+	deck := []*Card{}
+	for i := 0; i < 30; i++ {
+		deck = append(deck, &Card{Name: "card", Types: []CardType{{"unit"}}, Stats: &Stats{One, Two}})
+	}
+	game := NewGame(5)
+	game.AddPlayer(deck...).SetDecider(newRandomDecider(1, 50))
+	game.AddPlayer(deck...).SetDecider(newRandomDecider(2, 50))
+	game.Run()
+	for b.Loop() {
+		game.Clone()
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	deck := []*Card{}
+	for i := 0; i < 15; i++ {
+		deck = append(deck, newSimpleUnit("unit"))
+	}
+
+	// Nobody answers the channel prompts, cancelling must stop the game
+	game := NewGame(1)
+	game.AddPlayer(deck...)
+	game.AddPlayer(deck...)
+	ctx, cancel := context.WithCancel(context.Background())
+	game.On(EventPromptCard, func(e *Event) { cancel() })
+	result := game.RunContext(ctx)
+	if result.Reason != EndCancelled || !errors.Is(result.Err, context.Canceled) {
+		t.Fatalf("Expected cancelled game, got %v: %v", result.Reason, result.Err)
+	}
+
+	// The first player to time out forfeits
+	game = NewGame(1)
+	game.AddPlayer(deck...)
+	game.AddPlayer(deck...)
+	game.SetPromptTimeout(time.Millisecond, TimeoutForfeit)
+	result = game.Run()
+	if result.Reason != EndForfeit || len(result.Losers) != 1 || result.Winner == result.Losers[0] {
+		t.Fatalf("Expected forfeit, got %v", result.Reason)
+	}
+
+	// Skipping every prompt keeps the game going until the context ends
+	game = NewGame(1)
+	game.AddPlayer(deck...)
+	game.AddPlayer(deck...)
+	game.SetPromptTimeout(time.Millisecond, TimeoutSkip)
+	ctx, cancel = context.WithCancel(context.Background())
+	game.On(AllEvents, func(e *Event) {
+		if game.turn != nil && game.turn.turn > 3 {
+			cancel()
+		}
+	})
+	result = game.RunContext(ctx)
+	if result.Reason != EndCancelled || result.Turns != 4 {
+		t.Fatalf("Expected cancel in turn 4, got %v in turn %d", result.Reason, result.Turns)
+	}
+
+	// Answers that arrive after the prompt timed out don't answer the next one
+	p1 := newPlayer([]*Card{}, []*Card{}, []*Card{}, []*Card{})
+	p2 := newPlayer([]*Card{}, []*Card{}, []*Card{}, []*Card{})
+	game = newGame([]*Player{p1, p2})
+	game.SetPromptTimeout(time.Millisecond, TimeoutSkip)
+	late := make(chan struct{})
+	game.On(EventPromptCard, func(e *Event) {
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			p1.Send(Msg{Selected: []int{1}})
+			p1.Send(Msg{Selected: []int{1}})
+			close(late)
+		}()
+	})
+	game.On(EventPromptTarget, func(e *Event) { p1.Send(Msg{Selected: []int{0}}) })
+	selected := []int{}
+	p1.prompt("card", 1, []any{p1, p2}, &selected)
+	<-late
+	if selected[0] != SkipCode {
+		t.Fatalf("Expected the prompt to time out, got %v", selected)
+	}
+	selected = []int{}
+	p1.prompt("target", 1, []any{p1, p2}, &selected)
+	if selected[0] != 0 {
+		t.Fatalf("Expected the late answer to be dropped, got %v", selected)
+	}
+}
+
+func TestGameOver(t *testing.T) {
+	deck := []*Card{}
+	for i := 0; i < 15; i++ {
+		deck = append(deck, newSimpleUnit("unit"))
+	}
+
+	// Both players draw from an empty deck at the start
+	game := NewGame(1)
+	game.AddPlayer()
+	game.AddPlayer()
+	result := game.Run()
+	if result.Reason != EndDraw || result.Winner != nil || len(result.Losers) != 2 {
+		t.Fatalf("Expected a draw, got %v", result.Reason)
+	}
+
+	game = NewGame(1)
+	p1 := game.AddPlayer(deck...)
+	p2 := game.AddPlayer(deck...)
+	p1.SetDecider(newRandomDecider(1, 1000))
+	p2.SetDecider(newRandomDecider(2, 1000))
+	var winner *Player
+	game.On(EventAtStartPhase, func(e *Event) {
+		if game.turn.turn == 3 {
+			e.Player.LoseLife(20)
+		}
+	})
+	game.On(EventOnWin, func(e *Event) { winner = e.Player })
+	result = game.Run()
+	if result.Reason != EndLife || result.Turns != 3 || !result.Losers[0].Lost() {
+		t.Fatalf("Expected a loss by life in turn 3, got %v in turn %d", result.Reason, result.Turns)
+	}
+	if winner == nil || winner != result.Winner || winner == result.Losers[0] {
+		t.Fatalf("Expected the other player to win")
+	}
+
+	game = NewGame(1)
+	game.AddPlayer(deck...)
+	game.AddPlayer(deck...)
+	game.On(EventPromptCard, func(e *Event) {
+		e.Player.Send(Msg{Err: ErrConcede})
+	})
+	result = game.Run()
+	if result.Reason != EndConcede || result.Winner == nil || result.Winner.Lost() {
+		t.Fatalf("Expected a concede, got %v", result.Reason)
+	}
+}
+
+func TestStateBasedActions(t *testing.T) {
+	storm := parseCards(t, `Storm
+	Source
+	{t}: Storm deals 2 damage to units, then draw a card.`)[0]
+	big := &Card{Name: "big", Types: []CardType{{"unit"}}, Stats: &Stats{Three, Three}}
+	p1 := newPlayer(
+		[]*Card{storm, newSimpleUnit("small")},
+		[]*Card{newSimpleUnit("card1")},
+		[]*Card{},
+		[]*Card{},
+	)
+	p2 := newPlayer([]*Card{nil, big}, []*Card{}, []*Card{}, []*Card{})
+	game := newPlayGame(p1, p2)
+
+	token := NewCardInstance(&Card{Types: []CardType{{"unit"}, {"token"}}, Stats: &Stats{One, One}}, p1, ZoneBoard)
+	p1.Place(token, ZoneBoard, 2)
+	source := p1.board.Slots[0]
+	game.Play(source.Do(source.GetActivatedAbilities()[0]))
+	game.stack.Pop().Resolve()
+
+	small := p1.board.Slots[1]
+	if small == nil || len(p1.hand.Cards) != 1 {
+		t.Fatalf("Units left the board before the ability finished resolving")
+	}
+	if game.checkState() {
+		t.Fatalf("Game over after state check")
+	}
+	if p1.board.Slots[1] != nil || p1.board.Slots[2] != nil || len(p1.pile.Cards) != 1 || p1.pile.Cards[0] != small {
+		t.Fatalf("Dead units were not put in the pile")
+	}
+	if p2.board.Slots[1] == nil || p2.board.Slots[1].GetHealth().Number != 1 {
+		t.Fatalf("Surviving unit was destroyed")
+	}
+}
+
+func TestStaticAbilities(t *testing.T) {
+	cards := parseCards(t,
+		`Lord
+	Unit
+	Other units you control get +1/+1.
+	1/1`,
+		`Growth
+	Spell
+	Target unit gets +2/+0.`,
+	)
+	lord, growth := cards[0], cards[1]
+	p1 := newPlayer(
+		[]*Card{lord, newSimpleUnit("small")},
+		[]*Card{},
+		[]*Card{growth},
+		[]*Card{},
+	)
+	p2 := newPlayer([]*Card{nil, newSimpleUnit("enemy")}, []*Card{}, []*Card{}, []*Card{})
+	game := newPlayGame(p1, p2)
+
+	small := p1.board.Slots[1]
+	if s := small.GetStats(); s.Power.Number != 2 || s.Health.Number != 2 {
+		t.Fatalf("Static ability not applied: %v", s)
+	}
+	if s := p1.board.Slots[0].GetStats(); s.Power.Number != 1 {
+		t.Fatalf("Static ability applied to its source: %v", s)
+	}
+	if s := p2.board.Slots[1].GetStats(); s.Power.Number != 1 {
+		t.Fatalf("Static ability applied to an opponent's unit: %v", s)
+	}
+
+	game.On(EventPromptTarget, func(e *Event) {
+		for i, choice := range e.Args[1:] {
+			if choice == small {
+				p1.Send(Msg{Selected: []int{i}})
+			}
+		}
+	})
+	spell := p1.hand.Cards[0]
+	game.Play(spell.Cast(-1))
+	game.stack.Pop().Resolve()
+	if small.GetPower().Number != 4 || small.GetHealth().Number != 2 {
+		t.Fatalf("Modifier not applied: %v", small.GetStats())
+	}
+	if len(p1.pile.Cards) != 1 || p1.pile.Cards[0] != spell {
+		t.Fatalf("Spell not put in the pile after resolving")
+	}
+
+	game.checkState()
+	p1.Place(p1.board.Slots[0], ZonePile, -1)
+	if small.GetPower().Number != 3 || small.GetHealth().Number != 1 {
+		t.Fatalf("Static ability still applied after its source left: %v", small.GetStats())
+	}
+}
+
+func TestDurations(t *testing.T) {
+	cards := parseCards(t,
+		`Growth
+	Spell
+	Target unit gets +2/+0 until end of turn.`,
+		`Shield
+	Spell
+	Target unit gets +0/+2 until your next turn.`,
+	)
+	growth, shield := cards[0], cards[1]
+	p1 := newPlayer(
+		[]*Card{newSimpleUnit("unit")},
+		[]*Card{},
+		[]*Card{growth, shield},
+		[]*Card{},
+	)
+	p2 := newPlayer([]*Card{}, []*Card{}, []*Card{}, []*Card{})
+	game := newPlayGame(p1, p2)
+	game.On(EventPromptTarget, func(e *Event) { p1.Send(Msg{Selected: []int{0}}) })
+
+	unit := p1.board.Slots[0]
+	for len(p1.hand.Cards) > 0 {
+		game.Play(p1.hand.Cards[0].Cast(-1))
+		game.stack.Pop().Resolve()
+	}
+	if s := unit.GetStats(); s.Power.Number != 3 || s.Health.Number != 3 {
+		t.Fatalf("Modifiers not applied: %v", s)
+	}
+	for range game.turn.Iter() {
+	}
+	if s := unit.GetStats(); s.Power.Number != 1 || s.Health.Number != 3 {
+		t.Fatalf("Expected only the end of turn modifier to end: %v", s)
+	}
+	game.turn = &Turn{game, p2, nil, 2, 0}
+	for range game.turn.Iter() {
+	}
+	if s := unit.GetStats(); s.Health.Number != 3 {
+		t.Fatalf("Modifier ended in the opponent's turn: %v", s)
+	}
+	game.turn = &Turn{game, p1, nil, 3, 0}
+	game.turn.begin(PhaseStart)
+	if s := unit.GetStats(); s.Health.Number != 1 {
+		t.Fatalf("Modifier did not end at the start of the next turn: %v", s)
+	}
+
+	might := parseCards(t, "Might\nSpell\nTarget unit gets +2/+0.")[0]
+	game.turn.phase = &Phase{game.turn, p1, PhasePlay}
+	p1.hand.Add(NewCardInstance(might, p1, ZoneHand))
+	game.Play(p1.hand.Cards[0].Cast(-1))
+	game.stack.Pop().Resolve()
+	if s := unit.GetStats(); s.Power.Number != 3 {
+		t.Fatalf("Modifier not applied: %v", s)
+	}
+	p1.Place(unit, ZoneHand, -1)
+	p1.Place(unit, ZoneBoard, 0)
+	if s := unit.GetStats(); s.Power.Number != 1 {
+		t.Fatalf("Expected modifiers to end when the unit leaves the board: %v", s)
+	}
+}
+
+func newKeywordUnit(name string, keyword string, stats Stats) *Card {
+	card := &Card{Name: name, Types: []CardType{{"unit"}}, Stats: &stats}
+	if keyword != "" {
+		card.Abilities = []Ability{Keyword{keyword}}
+	}
+	return card
+}
+
+func TestKeywords(t *testing.T) {
+	attack := func(attacker, blocker *Card) (*Player, *CardInstance) {
+		p1 := newPlayer([]*Card{attacker}, []*Card{}, []*Card{}, []*Card{})
+		p2 := newPlayer([]*Card{blocker}, []*Card{}, []*Card{}, []*Card{})
+		game := newPlayGame(p1, p2)
+		game.On(EventPromptBlock, func(e *Event) { p2.Send(Msg{Selected: []int{0}}) })
+		defender := p2.board.Slots[0]
+		defender.activated = true
+		Attack{}.Resolve(&EffectInstance{Subjects: []any{p1.board.Slots[0]}})
+		game.turn.combat()
+		game.checkState()
+		return p2, defender
+	}
+
+	p, blocker := attack(newKeywordUnit("flyer", "fly", Stats{Two, Two}), newSimpleUnit("unit"))
+	if p.life != 8 || blocker.zone != ZoneBoard {
+		t.Errorf("Fly was blocked by a unit without fly")
+	}
+	p, blocker = attack(newKeywordUnit("flyer", "fly", Stats{Two, Two}), newKeywordUnit("bird", "fly", Stats{One, One}))
+	if p.life != 10 || blocker.zone != ZonePile {
+		t.Errorf("Fly was not blocked by a unit with fly")
+	}
+	p, blocker = attack(newKeywordUnit("ram", "siege", Stats{Two, Two}), newKeywordUnit("wall", "", Stats{Three, Three}))
+	if p.life != 8 || blocker.GetHealth().Number != 1 {
+		t.Errorf("Siege did not damage both the unit and the player")
+	}
+	p, blocker = attack(newKeywordUnit("snake", "poison", Stats{One, One}), newKeywordUnit("wall", "", Stats{Three, Three}))
+	if p.life != 10 || blocker.zone != ZonePile {
+		t.Errorf("Poison did not destroy the damaged unit")
+	}
+
+	p1 := newPlayer([]*Card{}, []*Card{}, []*Card{}, []*Card{})
+	p2 := newPlayer(
+		[]*Card{},
+		[]*Card{},
+		[]*Card{newSimpleUnit("unit"), newKeywordUnit("spider", "ambush", Stats{One, One})},
+		[]*Card{},
+	)
+	game := newPlayGame(p1, p2)
+	game.turn.phase.priority = p2
+	if p2.hand.Cards[0].CanPlay() || !p2.hand.Cards[1].CanPlay() {
+		t.Errorf("Only ambush units can be played in the opponent's turn")
+	}
+}
+
+func TestCombat(t *testing.T) {
+	guard := parseCards(t, `Guard
+	Unit
+	Whenever Guard blocks, draw a card.
+	1/1`)[0]
+	p1 := newPlayer([]*Card{nil, newKeywordUnit("attacker", "", Stats{Two, Two})}, []*Card{}, []*Card{}, []*Card{})
+	p2 := newPlayer(
+		[]*Card{guard, nil, nil, newKeywordUnit("wall", "", Stats{Three, Three})},
+		[]*Card{newSimpleUnit("card")},
+		[]*Card{},
+		[]*Card{},
+	)
+	game := newPlayGame(p1, p2)
+	for _, card := range p2.board.Slots {
+		if card != nil {
+			card.activated = true
+		}
+	}
+	attacker := p1.board.Slots[1]
+	blocker := p2.board.Slots[0]
+	if blockers := p2.Blockers(attacker); len(blockers) != 1 || blockers[0] != blocker {
+		t.Fatalf("Expected only the unit in the adjacent lane to block: %v", blockers)
+	}
+
+	events := []EventType{}
+	game.On(AllEvents, func(e *Event) {
+		switch e.Event {
+		case EventOnAttack, EventOnBlock, EventOnDamage, EventOnPlayerDamage:
+			events = append(events, e.Event)
+		case EventPromptBlock:
+			p2.Send(Msg{Selected: []int{0}})
+		}
+	})
+	Attack{}.Resolve(&EffectInstance{Subjects: []any{attacker}})
+	if attacker.GetHealth().Number != 2 || p2.life != 10 {
+		t.Fatalf("Expected combat damage to wait for the combat step")
+	}
+	game.turn.combat()
+	expected := []EventType{EventOnAttack, EventOnBlock, EventOnDamage, EventOnDamage}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("Expected events %v, got %v", expected, events)
+	}
+	if attacker.GetHealth().Number != 1 || blocker.GetHealth().Number != -1 || p2.life != 10 {
+		t.Fatalf("Combat damage was not dealt both ways")
+	}
+	if len(game.stack.cards) != 1 {
+		t.Fatalf("Block did not trigger")
+	}
+
+	// Two attackers face one unit that could block either of them
+	p1 = newPlayer([]*Card{newSimpleUnit("left"), nil, newSimpleUnit("right")}, []*Card{}, []*Card{}, []*Card{})
+	p2 = newPlayer([]*Card{nil, newKeywordUnit("wall", "", Stats{One, Three})}, []*Card{}, []*Card{}, []*Card{})
+	game = newPlayGame(p1, p2)
+	wall := p2.board.Slots[1]
+	wall.activated = true
+	prompts := 0
+	game.On(EventPromptBlock, func(e *Event) {
+		prompts += 1
+		if len(e.Args[1:]) != 2 || e.Args[0] != 2 {
+			t.Errorf("Expected one block per attacker to choose from, got %v", e.Args)
+		}
+		// Try to block both attackers with the same unit
+		p2.Send(Msg{Selected: []int{0, 1}})
+	})
+	for _, card := range p1.board.Slots {
+		if card != nil {
+			Attack{}.Resolve(&EffectInstance{Subjects: []any{card}})
+		}
+	}
+	game.turn.combat()
+	if prompts != 1 {
+		t.Fatalf("Expected blockers to be declared in one prompt, got %d", prompts)
+	}
+	if wall.damage != 1 || p2.life != 9 {
+		t.Fatalf("Expected a unit to block only one attacker, damage %d life %d", wall.damage, p2.life)
+	}
+	if p1.board.Slots[0].attacking || p1.board.Slots[2].attacking {
+		t.Fatalf("Expected the attackers to stop attacking after combat")
+	}
+}
+
+func TestDamage(t *testing.T) {
+	cards := parseCards(t,
+		`Mend
+	Spell
+	Heal 2 damage from target unit.`,
+		`Ward
+	Spell
+	Prevent the next 2 damage that would be dealt to target unit.`,
+	)
+	mend, ward := cards[0], cards[1]
+	p1 := newPlayer(
+		[]*Card{newKeywordUnit("wall", "", Stats{Three, Three})},
+		[]*Card{},
+		[]*Card{mend, ward},
+		[]*Card{},
+	)
+	p2 := newPlayer([]*Card{}, []*Card{}, []*Card{}, []*Card{})
+	game := newPlayGame(p1, p2)
+	healed := 0
+	game.On(AllEvents, func(e *Event) {
+		switch e.Event {
+		case EventPromptTarget:
+			p1.Send(Msg{Selected: []int{0}})
+		case EventOnHeal:
+			healed += e.Args[1].(int)
+		}
+	})
+
+	unit := p1.board.Slots[0]
+	unit.TakeDamage(2)
+	if unit.GetHealth().Number != 1 || unit.GetStats().Health.Number != 3 {
+		t.Fatalf("Damage changed the base health: %v", unit.GetStats())
+	}
+	for len(p1.hand.Cards) > 0 {
+		game.Play(p1.hand.Cards[0].Cast(-1))
+		game.stack.Pop().Resolve()
+	}
+	if unit.GetHealth().Number != 3 || healed != 2 {
+		t.Fatalf("Expected 2 damage to be healed, healed %d", healed)
+	}
+	if unit.TakeDamage(3) != 1 || unit.GetHealth().Number != 2 {
+		t.Fatalf("Expected 2 damage to be prevented, health %v", unit.GetHealth())
+	}
+	for range game.turn.Iter() {
+	}
+	if unit.GetDamage() != 0 {
+		t.Fatalf("Damage was not removed at the end of the turn")
+	}
+
+	game.SetDamageRule(DamagePersists)
+	unit.TakeDamage(1)
+	game.turn = &Turn{game, p1, nil, 2, 0}
+	for range game.turn.Iter() {
+	}
+	if unit.GetDamage() != 1 {
+		t.Fatalf("Damage did not persist")
+	}
+}
+
+func TestCounters(t *testing.T) {
+	cards := parseCards(t,
+		"Bless\nSpell\nPut a +1/+1 counter on target unit.",
+		"Drain\nSpell\nRemove a charge counter from target unit.",
+		"Purge\nSpell\nDestroy each unit with a +1/+1 counter on it.",
+	)
+	p1 := newPlayer(
+		[]*Card{newSimpleUnit("unit"), newSimpleUnit("other")},
+		[]*Card{},
+		cards,
+		[]*Card{},
+	)
+	p2 := newPlayer([]*Card{}, []*Card{}, []*Card{}, []*Card{})
+	game := newPlayGame(p1, p2)
+	counters := []int{}
+	game.On(AllEvents, func(e *Event) {
+		switch e.Event {
+		case EventPromptTarget:
+			p1.Send(Msg{Selected: []int{0}})
+		case EventOnCounter:
+			counters = append(counters, e.Args[2].(int))
+		}
+	})
+	cast := func() {
+		game.Play(p1.hand.Cards[0].Cast(-1))
+		game.stack.Pop().Resolve()
+		game.checkState()
+	}
+
+	unit, other := p1.board.Slots[0], p1.board.Slots[1]
+	cast()
+	if s := unit.GetStats(); s.Power.Number != 2 || s.Health.Number != 2 {
+		t.Fatalf("Counter did not change the stats: %v", s)
+	}
+	unit.AddCounter("charge", 2)
+	cast()
+	if unit.GetCounters("charge") != 1 || unit.GetCounters("") != 2 {
+		t.Fatalf("Expected a charge counter to be removed: %v", unit.counters)
+	}
+	if !reflect.DeepEqual(counters, []int{1, 2, -1}) {
+		t.Fatalf("Unexpected counter events %v", counters)
+	}
+	count := Count{Counters: &CounterCount{Objects: &CardMatch{[]CardTypeMatch{{Each: true, Type: CardType{"unit"}}}}}}
+	if n := count.Value(&AbilityInstance{Controller: p1}); n != 2 {
+		t.Fatalf("Expected 2 counters on units, got %d", n)
+	}
+	cast()
+	if unit.zone != ZonePile || other.zone != ZoneBoard || unit.GetCounters("") != 0 {
+		t.Fatalf("Expected only the unit with a counter to be destroyed")
+	}
+
+	other.AddCounter("-1/-1", 1)
+	game.checkState()
+	if other.zone != ZonePile {
+		t.Fatalf("Unit with a -1/-1 counter on 1 health was not destroyed")
+	}
+
+	surge := parseCards(t, "Surge {x}\nSpell\nPut a +X/+X counter on target unit.")[0]
+	p1.board.Insert(NewCardInstance(newSimpleUnit("unit"), p1, ZoneBoard), 0)
+	p1.hand.Add(NewCardInstance(surge, p1, ZoneHand))
+	p1.AddEssence("s")
+	p1.AddEssence("s")
+	game.On(EventPromptX, func(e *Event) { p1.Send(Msg{Selected: []int{2}}) })
+	cast()
+	if n := p1.board.Slots[0].GetCounters("+2/+2"); n != 1 {
+		t.Fatalf("Expected X in the counter to be its value, counters %v", p1.board.Slots[0].counters)
+	}
+}
+
+func TestLookAndPut(t *testing.T) {
+	cards := parseCards(t,
+		`Scry
+	Spell
+	Look at the top 2 cards of your deck, then put them on the bottom of your deck in any order.`,
+		`Recall
+	Spell
+	Put target unit on top of your deck.`,
+	)
+	scry, recall := cards[0], cards[1]
+	p1 := newPlayer(
+		[]*Card{newSimpleUnit("unit")},
+		[]*Card{newSimpleUnit("a"), newSimpleUnit("b"), newSimpleUnit("c")},
+		[]*Card{scry, recall},
+		[]*Card{},
+	)
+	p2 := newPlayer([]*Card{}, []*Card{}, []*Card{}, []*Card{})
+	game := newPlayGame(p1, p2)
+	looked := 0
+	game.On(AllEvents, func(e *Event) {
+		switch e.Event {
+		case EventPromptLook:
+			looked = len(e.Args) - 1
+			p1.Send(Msg{})
+		case EventPromptOrder:
+			p1.Send(Msg{Selected: []int{1, 0}})
+		case EventPromptTarget:
+			p1.Send(Msg{Selected: []int{0}})
+		}
+	})
+	names := func() []string {
+		list := []string{}
+		for _, card := range p1.deck.Cards {
+			list = append(list, card.GetName())
+		}
+		return list
+	}
+
+	game.Play(p1.hand.Cards[0].Cast(-1))
+	game.stack.Pop().Resolve()
+	if looked != 2 {
+		t.Fatalf("Expected to look at 2 cards, looked at %d", looked)
+	}
+	if deck := names(); !reflect.DeepEqual(deck, []string{"c", "b", "a"}) {
+		t.Fatalf("Expected the cards on the bottom in the chosen order, got %v", deck)
+	}
+	game.Play(p1.hand.Cards[0].Cast(-1))
+	game.stack.Pop().Resolve()
+	if deck := names(); !reflect.DeepEqual(deck, []string{"unit", "c", "b", "a"}) {
+		t.Fatalf("Expected the unit on top of the deck, got %v", deck)
+	}
+}
+
+func TestOptional(t *testing.T) {
+	study := parseCards(t, `Study
+	Spell
+	You may draw a card, then you gain 2 life.`)[0]
+	p1 := newPlayer(
+		[]*Card{},
+		[]*Card{newSimpleUnit("a"), newSimpleUnit("b")},
+		[]*Card{study, study},
+		[]*Card{},
+	)
+	p2 := newPlayer([]*Card{}, []*Card{}, []*Card{}, []*Card{})
+	game := newPlayGame(p1, p2)
+	answer := SkipCode
+	game.On(EventPromptConfirm, func(e *Event) { p1.Send(Msg{Selected: []int{answer}}) })
+
+	game.Play(p1.hand.Cards[0].Cast(-1))
+	game.stack.Pop().Resolve()
+	if len(p1.deck.Cards) != 2 || p1.life != 12 {
+		t.Fatalf("Declining skipped more than the optional effect")
+	}
+	answer = 0
+	game.Play(p1.hand.Cards[0].Cast(-1))
+	game.stack.Pop().Resolve()
+	if len(p1.deck.Cards) != 1 || p1.life != 14 {
+		t.Fatalf("Optional effect was not done after confirming")
+	}
+}
+
+func TestModal(t *testing.T) {
+	charm := parseCards(t, `Charm
+	Spell
+	Choose two —
+	• Draw a card.
+	• Target unit gets +2/+0 until end of turn.
+	• You gain 3 life.`)[0]
+	if text := charm.Abilities[0].(Composed).Text(); !strings.HasSuffix(text, "• You gain 3 life.") {
+		t.Errorf("Modes are not part of the ability text: %q", text)
+	}
+	if text := charm.Abilities[0].(Composed).Effects[0].(Modal).Modes[1].Text(); text != "Target unit gets +2/+0 until end of turn." {
+		t.Errorf("Expected the mode to have the text of its line: %q", text)
+	}
+	p1 := newPlayer(
+		[]*Card{newSimpleUnit("unit")},
+		[]*Card{newSimpleUnit("card")},
+		[]*Card{charm},
+		[]*Card{},
+	)
+	p2 := newPlayer([]*Card{}, []*Card{}, []*Card{}, []*Card{})
+	game := newPlayGame(p1, p2)
+	events := []EventType{}
+	answer := []int{2, 1}
+	game.On(AllEvents, func(e *Event) {
+		switch e.Event {
+		case EventPromptMode:
+			events = append(events, e.Event)
+			p1.Send(Msg{Selected: answer})
+		case EventPromptTarget:
+			events = append(events, e.Event)
+			p1.Send(Msg{Selected: []int{0}})
+		}
+	})
+
+	a := p1.hand.Cards[0].Cast(-1)
+	game.Play(a)
+	if !reflect.DeepEqual(events, []EventType{EventPromptMode, EventPromptTarget}) {
+		t.Fatalf("Expected modes to be chosen before targets, got %v", events)
+	}
+	if !reflect.DeepEqual(a.Modes, []int{1, 2}) || len(a.Effects) != 2 {
+		t.Fatalf("Expected only the effects of the chosen modes, got modes %v", a.Modes)
+	}
+	game.stack.Pop().Resolve()
+	if len(p1.hand.Cards) != 0 || p1.life != 13 || p1.board.Slots[0].GetPower().Number != 3 {
+		t.Fatalf("Chosen modes did not happen")
+	}
+
+	answer = []int{2, 2}
+	modes := charm.Abilities[0].(Composed).Effects[0].(Modal).Modes
+	if chosen := p1.chooseModes(modes, 2); !reflect.DeepEqual(chosen, []int{0, 2}) {
+		t.Fatalf("Expected missing modes to be made up by the first ones, got %v", chosen)
+	}
+}
+
+func TestConditionals(t *testing.T) {
+	cards := parseCards(t,
+		"Insight\nSpell\nDraw a card. If you control a wizard, draw 2 cards instead.",
+		"Shock\nSpell\nShock deals 3 damage to target unit unless its controller spends {1}.",
+		"Gamble\nSpell\nIf the number of units you control is 2 or greater, you gain 2 life. Otherwise, you lose 2 life.",
+	)
+	insight, shock, gamble := cards[0], cards[1], cards[2]
+	deck := []*Card{}
+	for range 5 {
+		deck = append(deck, newSimpleUnit("card"))
+	}
+	wizard := &Card{Name: "wizard", Types: []CardType{{"unit"}}, Subtypes: []SubType{{"wizard"}}, Stats: &Stats{One, One}}
+	p1 := newPlayer([]*Card{}, deck, []*Card{}, []*Card{})
+	p2 := newPlayer([]*Card{newSimpleUnit("unit"), newSimpleUnit("unit")}, []*Card{}, []*Card{}, []*Card{})
+	game := newPlayGame(p1, p2)
+	var target *CardInstance
+	game.On(AllEvents, func(e *Event) {
+		switch e.Event {
+		case EventPromptTarget:
+			p1.Send(Msg{Selected: []int{slices.Index(e.Args[1:], any(target))}})
+		case EventPromptUnless:
+			if cost, ok := e.Args[len(e.Args)-1].(CostType); len(e.Args) != 3 || !ok || cost.Number.Number != 1 {
+				t.Errorf("Expected the cost to pay with the prompt, got %v", e.Args[1:])
+			}
+			e.Player.Send(Msg{Selected: []int{0}})
+		}
+	})
+	cast := func(card *Card) {
+		c := NewCardInstance(card, p1, ZoneHand)
+		p1.hand.Add(c)
+		game.Play(c.Cast(-1))
+		game.stack.Pop().Resolve()
+	}
+
+	cast(insight)
+	if len(p1.deck.Cards) != 4 {
+		t.Fatalf("Expected 1 card to be drawn without a wizard, deck has %d", len(p1.deck.Cards))
+	}
+	p1.Place(NewCardInstance(wizard, p1, ZoneHand), ZoneBoard, 0)
+	cast(insight)
+	if len(p1.deck.Cards) != 2 {
+		t.Fatalf("Expected 2 cards to be drawn instead with a wizard, deck has %d", len(p1.deck.Cards))
+	}
+
+	target = p2.board.Slots[0]
+	cast(shock)
+	if target.GetDamage() != 3 {
+		t.Fatalf("Expected the damage to be dealt when the controller can't pay")
+	}
+	p2.AddEssence("s")
+	target = p2.board.Slots[1]
+	cast(shock)
+	if target.GetDamage() != 0 || len(p2.essence) != 0 {
+		t.Fatalf("Expected the damage to be stopped by paying")
+	}
+
+	cast(gamble)
+	if p1.life != 8 {
+		t.Fatalf("Expected the otherwise effect with one unit, life %d", p1.life)
+	}
+	p1.Place(NewCardInstance(wizard, p1, ZoneHand), ZoneBoard, 1)
+	cast(gamble)
+	if p1.life != 10 {
+		t.Fatalf("Expected the conditional effect with two units, life %d", p1.life)
+	}
+}
+
+func TestScaling(t *testing.T) {
+	cards := parseCards(t,
+		"Tide\nSpell\nDraw a card for each unit you control.",
+		"Blast\nSpell\nBlast deals X damage to target unit, where X is the number of units you control.",
+		"Harvest\nSpell\nYou gain life equal to the number of sword cards in your pile.",
+	)
+	tide, blast, harvest := cards[0], cards[1], cards[2]
+	deck := []*Card{}
+	for range 5 {
+		deck = append(deck, newSimpleUnit("card"))
+	}
+	swords := []*Card{newSimpleUnit("sword"), newSimpleUnit("sword"), newSimpleUnit("cup")}
+	p1 := newPlayer([]*Card{newSimpleUnit("unit"), newSimpleUnit("unit")}, deck, []*Card{}, swords)
+	p2 := newPlayer([]*Card{newKeywordUnit("wall", "", Stats{One, NumberOrX{Number: 5}})}, []*Card{}, []*Card{}, []*Card{newSimpleUnit("sword")})
+	game := newPlayGame(p1, p2)
+	game.On(EventPromptTarget, func(e *Event) {
+		p1.Send(Msg{Selected: []int{slices.Index(e.Args[1:], any(p2.board.Slots[0]))}})
+	})
+	cast := func(card *Card) {
+		c := NewCardInstance(card, p1, ZoneHand)
+		p1.hand.Add(c)
+		game.Play(c.Cast(-1))
+		game.stack.Pop().Resolve()
+	}
+
+	units := &CardMatch{[]CardTypeMatch{{Type: CardType{"unit"}}}}
+	if n := (Count{Objects: units}).Value(&AbilityInstance{Controller: p1}); n != 3 {
+		t.Fatalf("Expected only the units on the board to be counted without a zone, got %d", n)
+	}
+	if n := (Count{Objects: units, Zone: &ZoneMatch{Z: []Zone{ZonePile}}}).Value(&AbilityInstance{Controller: p1}); n != 4 {
+		t.Fatalf("Expected the units in the piles to be counted, got %d", n)
+	}
+	cast(tide)
+	if len(p1.deck.Cards) != 3 {
+		t.Fatalf("Expected a card to be drawn for each of the 2 units, deck has %d", len(p1.deck.Cards))
+	}
+	cast(blast)
+	if damage := p2.board.Slots[0].GetDamage(); damage != 2 {
+		t.Fatalf("Expected X to be the number of units, dealt %d damage", damage)
+	}
+	cast(harvest)
+	if p1.life != 12 {
+		t.Fatalf("Expected to gain a life for each sword card in the pile, life %d", p1.life)
+	}
+}
+
+func TestXCosts(t *testing.T) {
+	fireball := parseCards(t, "Fireball {x}{s}\nSpell\nFireball deals X damage to target unit.")[0]
+	p1 := newPlayer([]*Card{}, []*Card{}, []*Card{fireball}, []*Card{})
+	p2 := newPlayer([]*Card{newKeywordUnit("wall", "", Stats{One, NumberOrX{Number: 5}})}, []*Card{}, []*Card{}, []*Card{})
+	game := newPlayGame(p1, p2)
+	for _, e := range []string{"s", "s", "w", "c"} {
+		p1.AddEssence(e)
+	}
+	offered := []any{}
+	game.On(AllEvents, func(e *Event) {
+		switch e.Event {
+		case EventPromptX:
+			offered = e.Args[1:]
+			p1.Send(Msg{Selected: []int{2}})
+		case EventPromptPay:
+			p1.Send(Msg{Selected: []int{0}})
+		case EventPromptTarget:
+			p1.Send(Msg{Selected: []int{slices.Index(e.Args[1:], any(p2.board.Slots[0]))}})
+		}
+	})
+	if !p1.hand.Cards[0].CanPlay() {
+		t.Fatalf("Expected Fireball to be castable with X as 0")
+	}
+	if n := p1.MaxX(p1.hand.Cards[0], p1.hand.Cards[0].GetCosts()); n != 3 {
+		t.Fatalf("Expected X to be at most 3, got %d", n)
+	}
+	a := p1.hand.Cards[0].Cast(-1)
+	if len(offered) != 4 || a.X != 2 {
+		t.Fatalf("Expected to choose X 2 of %v, got %d", offered, a.X)
+	}
+	if len(p1.essence) != 1 {
+		t.Fatalf("Expected X and {s} to be paid, essence left %v", p1.essence)
+	}
+	game.Play(a)
+	game.stack.Pop().Resolve()
+	if damage := p2.board.Slots[0].GetDamage(); damage != 2 {
+		t.Fatalf("Expected Fireball to deal X damage, dealt %d", damage)
+	}
+}
+
+func TestActionCosts(t *testing.T) {
+	cards := parseCards(t,
+		"Altar\nItem\nSacrifice a unit: put the sacrificed unit on top of your deck.",
+		"Library\nItem\nDiscard a card, spend 2 life: draw 2 cards.",
+		"Ritual\nSpell\nSacrifice a unit, draw a card.",
+	)
+	p1 := newPlayer([]*Card{cards[0], cards[1]}, []*Card{newSimpleUnit("card"), newSimpleUnit("card")}, []*Card{}, []*Card{})
+	p2 := newPlayer([]*Card{newSimpleUnit("unit")}, []*Card{}, []*Card{}, []*Card{})
+	game := newPlayGame(p1, p2)
+	if _, ok := cards[2].Abilities[0].(Composed); !ok {
+		t.Fatalf("Expected a sacrifice without a colon to be a spell effect, got %T", cards[2].Abilities[0])
+	}
+	var sacrificed *CardInstance
+	game.On(AllEvents, func(e *Event) {
+		switch e.Event {
+		case EventPromptSacrifice:
+			e.Player.Send(Msg{Selected: []int{slices.Index(e.Args[1:], any(sacrificed))}})
+		case EventPromptDiscard:
+			e.Player.Send(Msg{Selected: []int{0}})
+		}
+	})
+	altar, library := p1.board.Slots[0], p1.board.Slots[1]
+	sacrifice, draw := altar.GetActivatedAbilities()[0], library.GetActivatedAbilities()[0]
+	if sacrifice.CanDo(altar) {
+		t.Fatalf("Expected the sacrifice cost to need a unit to sacrifice")
+	}
+	if draw.CanDo(library) {
+		t.Fatalf("Expected the discard cost to need a card in hand")
+	}
+
+	kept := NewCardInstance(newSimpleUnit("unit"), p1, ZoneHand)
+	p1.Place(kept, ZoneBoard, 2)
+	unit := NewCardInstance(newSimpleUnit("unit"), p1, ZoneHand)
+	p1.Place(unit, ZoneBoard, 3)
+	if !sacrifice.CanDo(altar) {
+		t.Fatalf("Expected the sacrifice cost to be payable with a unit")
+	}
+	sacrificed = unit
+	a := altar.Do(sacrifice)
+	if unit.zone != ZonePile || !IsIn(unit, a.Sacrificed) || kept.zone != ZoneBoard || p2.board.Slots[0] == nil {
+		t.Fatalf("Expected only the chosen own unit to be sacrificed before the ability is played")
+	}
+	game.Play(a)
+	game.stack.Pop().Resolve()
+	if p1.deck.Cards[0] != unit {
+		t.Fatalf("Expected the sacrificed unit to be put on top of the deck")
+	}
+
+	p1.Draw(1)
+	p1.life = 2
+	if !draw.CanDo(library) {
+		t.Fatalf("Expected the costs to be payable with a card and 2 life")
+	}
+	p1.life = 1
+	if draw.CanDo(library) {
+		t.Fatalf("Expected the life cost to need enough life")
+	}
+	p1.life = 10
+	a = library.Do(draw)
+	if len(p1.hand.Cards) != 0 || len(p1.pile.Cards) != 1 || p1.life != 8 {
+		t.Fatalf("Expected a card to be discarded and 2 life spent, life %d", p1.life)
+	}
+	a.Resolve()
+	if len(p1.hand.Cards) != 2 {
+		t.Fatalf("Expected 2 cards to be drawn after paying")
+	}
+}
+
+func TestHybridCosts(t *testing.T) {
+	bolt := parseCards(t, "Bolt {s/o}{o/w}{u}\nSpell\nBolt deals 1 damage to target unit.")[0]
+	costs := []string{}
+	for _, ct := range bolt.Costs {
+		costs = append(costs, ct.String())
+	}
+	if got := strings.Join(costs, ""); got != "{s/o}{o/w}{u}" {
+		t.Fatalf("Expected hybrid and wild costs, got %s", got)
+	}
+	p1 := newPlayer([]*Card{}, []*Card{}, []*Card{}, []*Card{})
+	game := newGame([]*Player{p1})
+	card := NewCardInstance(bolt, p1, ZoneHand)
+	for _, test := range []struct {
+		pool []string
+		ok   bool
+	}{
+		{[]string{"o", "s", "c"}, true},
+		{[]string{"o", "o", "u"}, true},
+		{[]string{"s", "s", "c"}, false},
+		{[]string{"o", "s"}, false},
+	} {
+		p1.essence = test.pool
+		if p1.CanPay(card, card.GetCosts()) != test.ok {
+			t.Errorf("Expected paying with %v to be %v", test.pool, test.ok)
+		}
+	}
+
+	prompted := []any{}
+	game.On(EventPromptPay, func(e *Event) {
+		prompted = e.Args[1:]
+		p1.Send(Msg{Selected: []int{1}})
+	})
+	p1.essence = []string{"s", "o", "c"}
+	p1.Pay(&AbilityInstance{Source: card, Controller: p1}, []AbilityCost{{Cost: &CostType{Number: NumberOrX{Number: 1}}}})
+	if len(prompted) != 3 || !reflect.DeepEqual(p1.essence, []string{"s", "c"}) {
+		t.Fatalf("Expected to choose the essence to pay with from %v, left %v", prompted, p1.essence)
+	}
+	prompted = nil
+	p1.Pay(&AbilityInstance{Source: card, Controller: p1}, []AbilityCost{{Cost: &CostType{Color: "s", Hybrid: []string{"c"}}}, {Cost: &CostType{Color: "u"}}})
+	if prompted != nil || len(p1.essence) != 0 {
+		t.Fatalf("Expected no prompt when the whole pool is spent, left %v", p1.essence)
+	}
+}
+
+func TestAutoTap(t *testing.T) {
+	cards := parseCards(t,
+		"Land\nSource\n{t}: Add {s}.",
+		"Grove\nSource\n{t}: Add {o}.",
+		"Spark {s}{o}\nSpell\nSpark deals 1 damage to target unit.",
+		"Surge {s}{s}{s}\nSpell\nSurge deals 3 damage to target unit.",
+	)
+	land, grove, spark, surge := cards[0], cards[1], cards[2], cards[3]
+	p1 := newPlayer([]*Card{land, land, grove}, []*Card{}, []*Card{spark, surge}, []*Card{})
+	p2 := newPlayer([]*Card{newSimpleUnit("unit")}, []*Card{}, []*Card{}, []*Card{})
+	game := newPlayGame(p1, p2)
+	game.On(EventPromptTarget, func(e *Event) {
+		p1.Send(Msg{Selected: []int{slices.Index(e.Args[1:], any(p2.board.Slots[0]))}})
+	})
+
+	for _, c := range p1.board.Slots[:3] {
+		c.activated = true
+	}
+	playable := p1.GetPlayableCards()
+	if !IsIn(p1.hand.Cards[0], playable) || IsIn(p1.hand.Cards[1], playable) {
+		t.Fatalf("Expected only the spell the sources can pay for to be playable")
+	}
+	game.Play(p1.hand.Cards[0].Cast(-1))
+	tapped := 0
+	for _, c := range p1.board.Slots[:3] {
+		if !c.activated {
+			tapped++
+		}
+	}
+	if tapped != 2 || p1.board.Slots[2].activated || len(p1.essence) != 0 {
+		t.Fatalf("Expected a land and the grove to be tapped to pay, %d tapped, essence %v", tapped, p1.essence)
+	}
+	game.stack.Pop().Resolve()
+	if p2.board.Slots[0].GetDamage() != 1 {
+		t.Fatalf("Expected the spell to resolve after paying")
+	}
+
+	fireball := parseCards(t, "Fireball {x}{s}\nSpell\nFireball deals X damage to target unit.")[0]
+	p1 = newPlayer([]*Card{land, land, land}, []*Card{}, []*Card{fireball}, []*Card{})
+	p2 = newPlayer([]*Card{newKeywordUnit("wall", "", Stats{One, NumberOrX{Number: 5}})}, []*Card{}, []*Card{}, []*Card{})
+	game = newPlayGame(p1, p2)
+	game.On(AllEvents, func(e *Event) {
+		switch e.Event {
+		case EventPromptX:
+			p1.Send(Msg{Selected: []int{len(e.Args[1:]) - 1}})
+		case EventPromptTarget:
+			p1.Send(Msg{Selected: []int{slices.Index(e.Args[1:], any(p2.board.Slots[0]))}})
+		}
+	})
+	for _, c := range p1.board.Slots[:3] {
+		c.activated = true
+	}
+	card := p1.hand.Cards[0]
+	if n := p1.MaxX(card, card.GetCosts()); !card.CanPlay() || n != 2 {
+		t.Fatalf("Expected the sources to pay for X up to 2, got %d", n)
+	}
+	game.Play(card.Cast(-1))
+	game.stack.Pop().Resolve()
+	untapped := slices.ContainsFunc(p1.board.Slots[:3], func(c *CardInstance) bool { return c.activated })
+	if damage := p2.board.Slots[0].GetDamage(); damage != 2 || untapped {
+		t.Fatalf("Expected all sources to be tapped to pay X, dealt %d", damage)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	cards := parseCards(t,
+		"Bolt\nSpell\nBolt deals 2 damage to target unit.",
+		"Mend\nReaction Spell\nPrevent the next 2 damage that would be dealt to target unit.",
+	)
+	bolt, mend := cards[0], cards[1]
+	wall := newKeywordUnit("wall", "", Stats{One, Three})
+	p1 := newPlayer([]*Card{}, []*Card{}, []*Card{bolt}, []*Card{})
+	p2 := newPlayer([]*Card{wall}, []*Card{}, []*Card{bolt, mend}, []*Card{})
+	game := newPlayGame(p1, p2)
+	target := p2.board.Slots[0]
+	responded, answered := false, false
+	game.On(AllEvents, func(e *Event) {
+		switch e.Event {
+		case EventPromptCard:
+			choice := SkipCode
+			for i, c := range e.Args[1:] {
+				card := c.(*CardInstance)
+				if e.Player == p2 && card.Card == bolt {
+					t.Errorf("Expected spells to wait for their controller's turn")
+				}
+				if e.Player == p1 && len(p1.hand.Cards) > 0 || e.Player == p2 && card.Card == mend {
+					choice = i
+				}
+			}
+			if e.Player == p2 && choice != SkipCode {
+				responded = true
+			} else if e.Player == p1 && responded {
+				answered = true
+			}
+			e.Player.Send(Msg{Selected: []int{choice}})
+		case EventPromptTarget:
+			e.Player.Send(Msg{Selected: []int{slices.Index(e.Args[1:], any(target))}})
+		}
+	})
+	for player := range game.turn.phase.Iter() {
+		if !player.Run() {
+			t.Fatalf("Prompt failed")
+		}
+	}
+	if !responded || !answered {
+		t.Fatalf("Expected the other player to respond and the active player to get priority again")
+	}
+	if len(game.stack.cards) != 0 || len(p1.pile.Cards) != 1 || target.GetDamage() != 0 {
+		t.Fatalf("Expected the reaction to resolve first and prevent the damage, damage %d", target.GetDamage())
+	}
+	ambusher := newKeywordUnit("ambusher", "ambush", Stats{One, One})
+	p2.board.Insert(NewCardInstance(ambusher, p2, ZoneBoard), 1)
+	unit := p2.board.Slots[1]
+	unit.activated = true
+	if !unit.IsReaction() || unit.CanActivate(AttackAbility) || unit.CanDo() {
+		t.Fatalf("Expected an ambush unit to attack only on its controller's turn")
+	}
+	game.turn.player, game.turn.phase.priority = p2, p2
+	if !unit.CanActivate(AttackAbility) {
+		t.Fatalf("Expected an ambush unit to attack on its controller's turn")
+	}
+}
+
+func TestStackEffects(t *testing.T) {
+	cards := parseCards(t,
+		"Bolt\nSpell\nBolt deals 1 damage to target unit.",
+		"Negate\nReaction Spell\nCounter target spell.",
+		"Stifle\nReaction Spell\nCounter target ability.",
+		"Rewind\nReaction Spell\nReturn target spell to its owner's hand.",
+		"Echo\nReaction Spell\nCopy target spell.",
+		"Well\nItem\n{t}: Draw a card.",
+	)
+	bolt, negate, stifle, rewind, echo, well := cards[0], cards[1], cards[2], cards[3], cards[4], cards[5]
+	wall := newKeywordUnit("wall", "", Stats{One, Three})
+	p1 := newPlayer([]*Card{well}, []*Card{newSimpleUnit("card")}, []*Card{bolt, echo}, []*Card{})
+	p2 := newPlayer([]*Card{wall}, []*Card{}, []*Card{negate, rewind, stifle}, []*Card{})
+	game := newPlayGame(p1, p2)
+	var target any = p2.board.Slots[0]
+	countered := 0
+	game.On(AllEvents, func(e *Event) {
+		switch e.Event {
+		case EventPromptTarget:
+			e.Player.Send(Msg{Selected: []int{slices.Index(e.Args[1:], target)}})
+		case EventOnCountered:
+			countered++
+		}
+	})
+	cast := func(p *Player, card *Card) *CardInstance {
+		game.turn.phase.priority = p
+		for _, c := range p.hand.Cards {
+			if c.Card == card {
+				game.Play(c.Cast(-1))
+				return c
+			}
+		}
+		t.Fatalf("Card %s not in hand", card.Name)
+		return nil
+	}
+	resolve := func() {
+		for len(game.stack.cards) > 0 {
+			game.stack.Pop().Resolve()
+		}
+	}
+
+	target = p2.board.Slots[0]
+	spell := cast(p1, bolt)
+	target = game.stack.Top()
+	cast(p2, negate)
+	game.stack.Pop().Resolve()
+	if len(game.stack.cards) != 0 || spell.zone != ZonePile || countered != 1 {
+		t.Fatalf("Expected the countered spell to be in the pile")
+	}
+
+	p1.hand.Add(spell)
+	spell.zone = ZoneHand
+	target = p2.board.Slots[0]
+	cast(p1, bolt)
+	target = game.stack.Top()
+	cast(p2, rewind)
+	resolve()
+	if spell.zone != ZoneHand || p2.board.Slots[0].GetDamage() != 0 {
+		t.Fatalf("Expected the spell to be returned to its owner's hand")
+	}
+
+	target = p2.board.Slots[0]
+	cast(p1, bolt)
+	original := game.stack.Top()
+	target = original
+	cast(p1, echo)
+	target = p2.board.Slots[0]
+	game.stack.Pop().Resolve()
+	if copied := game.stack.Top(); len(game.stack.cards) != 2 || !copied.Copy || copied.Source != spell {
+		t.Fatalf("Expected a copy of the spell on the stack")
+	}
+	// The copy and the original share their card, but are different targets
+	p2.hand.Add(NewCardInstance(negate, p2, ZoneHand))
+	target = original
+	cast(p2, negate)
+	game.stack.Pop().Resolve()
+	if len(game.stack.cards) != 1 || !game.stack.Top().Copy || spell.zone != ZonePile {
+		t.Fatalf("Expected the original spell to be countered and its copy to remain")
+	}
+	resolve()
+	if p2.board.Slots[0].GetDamage() != 1 || spell.zone != ZonePile {
+		t.Fatalf("Expected only the copy to deal damage")
+	}
+
+	source := p1.board.Slots[0]
+	source.activated = true
+	game.turn.phase.priority = p1
+	game.Play(source.Do(source.GetActivatedAbilities()[0]))
+	target = game.stack.Top()
+	cast(p2, stifle)
+	resolve()
+	if len(p1.hand.Cards) != 0 || countered != 3 {
+		t.Fatalf("Expected the countered ability not to resolve")
+	}
+}
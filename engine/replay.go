@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const ReplayVersion = 1
+
+var ErrReplayEnded = errors.New("replay ended")
+
+// Replay holds everything needed to play a game again: the seed, the
+// decklists and every message the players answered prompts with, in the
+// order the engine consumed them.
+type Replay struct {
+	Version int         `json:"version"`
+	Seed    int64       `json:"seed"`
+	Decks   [][]CardID  `json:"decks"`
+	Msgs    []ReplayMsg `json:"msgs"`
+}
+
+type ReplayMsg struct {
+	Player   int    `json:"player"`
+	Selected []int  `json:"selected,omitempty"`
+	Err      string `json:"err,omitempty"`
+}
+
+type replayFeed struct {
+	replay *Replay
+	next   int
+}
+
+// Record starts recording the game into the returned replay. It must be
+// called after all players are added and before the game is run. The replay
+// is complete once Run returns.
+func (g *GameState) Record() *Replay {
+	r := &Replay{Version: ReplayVersion, Seed: g.seed, Decks: [][]CardID{}, Msgs: []ReplayMsg{}}
+	for _, p := range g.Players {
+		deck := []CardID{}
+		for _, card := range p.decklist {
+			deck = append(deck, card.ID)
+		}
+		r.Decks = append(r.Decks, deck)
+	}
+	g.recording = r
+	return r
+}
+
+func (r *Replay) record(g *GameState, p *Player, msg Msg) {
+	m := ReplayMsg{Player: g.playerIndex(p), Selected: msg.Selected}
+	if msg.Err != nil {
+		m.Err = msg.Err.Error()
+	}
+	r.Msgs = append(r.Msgs, m)
+}
+
+// Load recreates the recorded game. Every player is given a decider that
+// answers from the replay, once the messages run out every prompt fails with
+// ErrReplayEnded.
+func (r *Replay) Load(lookup func(CardID) *Card) (*GameState, error) {
+	if r.Version != ReplayVersion {
+		return nil, fmt.Errorf("unsupported replay version %d", r.Version)
+	}
+	g := NewGame(r.Seed)
+	for _, ids := range r.Decks {
+		deck := []*Card{}
+		for _, id := range ids {
+			card := lookup(id)
+			if card == nil {
+				return nil, fmt.Errorf("unknown card %d in replay", id)
+			}
+			deck = append(deck, card)
+		}
+		g.AddPlayer(deck...)
+	}
+	feed := &replayFeed{replay: r}
+	for _, p := range g.Players {
+		p.SetDecider(feed)
+	}
+	return g, nil
+}
+
+func (f *replayFeed) read(p *Player) ([]int, error) {
+	if f.next >= len(f.replay.Msgs) {
+		return nil, ErrReplayEnded
+	}
+	m := f.replay.Msgs[f.next]
+	if m.Player != p.game.playerIndex(p) {
+		return nil, fmt.Errorf("replay diverged at message %d: expected player %d", f.next, m.Player)
+	}
+	f.next += 1
+	for _, err := range []error{ErrPromptTimeout, ErrConcede} {
+		if m.Err == err.Error() {
+			return m.Selected, err
+		}
+	}
+	if m.Err != "" {
+		return m.Selected, errors.New(m.Err)
+	}
+	return m.Selected, nil
+}
+
+func (f *replayFeed) choose(p *Player) (int, error) {
+	selected, err := f.read(p)
+	if err != nil {
+		return ErrorCode, err
+	}
+	if len(selected) == 0 {
+		return SkipCode, nil
+	}
+	return selected[0], nil
+}
+
+func (f *replayFeed) ChooseCard(p *Player, choices []any) (int, error)    { return f.choose(p) }
+func (f *replayFeed) ChooseAbility(p *Player, choices []any) (int, error) { return f.choose(p) }
+func (f *replayFeed) ChooseField(p *Player, choices []any) (int, error)   { return f.choose(p) }
+func (f *replayFeed) ChooseTarget(p *Player, choices []any) (int, error)  { return f.choose(p) }
+func (f *replayFeed) ChooseSource(p *Player) (int, error)                 { return f.choose(p) }
+func (f *replayFeed) ChooseX(p *Player, choices []any) (int, error)       { return f.choose(p) }
+func (f *replayFeed) ChooseEssence(p *Player, choices []any) (int, error) { return f.choose(p) }
+
+func (f *replayFeed) ChooseDiscard(p *Player, n int, choices []any) ([]int, error) {
+	return f.read(p)
+}
+
+func (f *replayFeed) ChooseSacrifice(p *Player, n int, choices []any) ([]int, error) {
+	return f.read(p)
+}
+
+func (f *replayFeed) ChooseBlock(p *Player, choices []any) ([]int, error) {
+	return f.read(p)
+}
+
+func (f *replayFeed) ChooseModes(p *Player, n int, modes []any) ([]int, error) {
+	return f.read(p)
+}
+
+func (f *replayFeed) ChooseOrder(p *Player, cards []any) ([]int, error) {
+	return f.read(p)
+}
+
+func (f *replayFeed) Confirm(p *Player, card *CardInstance) (bool, error) {
+	choice, err := f.choose(p)
+	return choice == 0, err
+}
+
+func (f *replayFeed) ConfirmPay(p *Player, card *CardInstance, costs []CostType) (bool, error) {
+	choice, err := f.choose(p)
+	return choice == 0, err
+}
+
+func (f *replayFeed) Look(p *Player, cards []any) error {
+	_, err := f.read(p)
+	return err
+}
+
+func (r *Replay) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func ReadReplay(rd io.Reader) (*Replay, error) {
+	r := &Replay{}
+	if err := json.NewDecoder(rd).Decode(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
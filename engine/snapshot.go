@@ -0,0 +1,541 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand"
+	"reflect"
+)
+
+const SnapshotVersion = 1
+
+// Snapshot is a serializable copy of a game in progress. Cards refer to
+// their definitions by CardID and to each other by object id. A snapshot
+// should be taken while the engine waits on a player, it resumes at the
+// start of the priority player's action.
+type Snapshot struct {
+	Version   int               `json:"version"`
+	Seed      int64             `json:"seed"`
+	RandDraws uint64            `json:"rand_draws"`
+	NextId    int               `json:"next_id"`
+	Timestamp int               `json:"timestamp"`
+	Damage    DamageRule        `json:"damage_rule,omitempty"`
+	Turn      *TurnSnapshot     `json:"turn,omitempty"`
+	Players   []PlayerSnapshot  `json:"players"`
+	Stack     []AbilitySnapshot `json:"stack"`
+}
+
+type TurnSnapshot struct {
+	Number        int       `json:"number"`
+	Player        int       `json:"player"`
+	Phase         PhaseType `json:"phase"`
+	Priority      int       `json:"priority"`
+	SourcesPlayed int       `json:"sources_played"`
+}
+
+type PlayerSnapshot struct {
+	Id         int             `json:"id"`
+	Nr         int             `json:"nr"`
+	Life       int             `json:"life"`
+	TurnsAfter int             `json:"turns_after"`
+	Lost       bool            `json:"lost,omitempty"`
+	Losing     bool            `json:"losing,omitempty"`
+	Loss       EndReason       `json:"loss,omitempty"`
+	Essence    []string        `json:"essence"`
+	Decklist   []CardID        `json:"decklist"`
+	Deck       []CardSnapshot  `json:"deck"`
+	Hand       []CardSnapshot  `json:"hand"`
+	Pile       []CardSnapshot  `json:"pile"`
+	Board      []*CardSnapshot `json:"board"`
+}
+
+type CardSnapshot struct {
+	Id         int            `json:"id"`
+	Card       CardID         `json:"card"`
+	Token      *TokenSnapshot `json:"token,omitempty"`
+	Owner      int            `json:"owner"`
+	Controller int            `json:"controller"`
+	Activated  bool           `json:"activated"`
+	Attacking  bool           `json:"attacking,omitempty"`
+	Flipped    bool           `json:"flipped"`
+	Destroyed  bool           `json:"destroyed,omitempty"`
+	Timestamp  int            `json:"timestamp,omitempty"`
+	Damage     int            `json:"damage,omitempty"`
+	Prevent    int            `json:"prevent,omitempty"`
+	Counters   map[string]int `json:"counters,omitempty"`
+	Stats      *Stats         `json:"stats,omitempty"`
+	Modifiers  []Mods         `json:"modifiers,omitempty"`
+}
+
+// TokenSnapshot describes a token, tokens have no card definition to refer to.
+type TokenSnapshot struct {
+	Types    []string `json:"types"`
+	Subtypes []string `json:"subtypes,omitempty"`
+	Stats    *Stats   `json:"stats,omitempty"`
+}
+
+type AbilitySnapshot struct {
+	Id         int              `json:"id,omitempty"`
+	Kind       string           `json:"kind"`
+	Index      int              `json:"index"`
+	Source     int              `json:"source"`
+	Card       *CardSnapshot    `json:"card,omitempty"`
+	Controller int              `json:"controller"`
+	Field      int              `json:"field"`
+	X          int              `json:"x"`
+	Modes      []int            `json:"modes,omitempty"`
+	Event      EventType        `json:"event"`
+	Copy       bool             `json:"copy,omitempty"`
+	This       []int            `json:"this,omitempty"`
+	Sacrificed []int            `json:"sacrificed,omitempty"`
+	Targeting  []int            `json:"targeting,omitempty"`
+	Effects    []EffectSnapshot `json:"effects,omitempty"`
+}
+
+type EffectSnapshot struct {
+	Subjects []int `json:"subjects,omitempty"`
+	Matches  []int `json:"matches,omitempty"`
+}
+
+// countingSource counts the values drawn from a seeded source so the
+// generator can be brought back to the same position after a restore.
+type countingSource struct {
+	src   rand.Source64
+	draws uint64
+}
+
+func newCountingSource(seed int64, draws uint64) *countingSource {
+	s := &countingSource{src: rand.NewSource(seed).(rand.Source64)}
+	for s.draws < draws {
+		s.Uint64()
+	}
+	return s
+}
+
+func (s *countingSource) Int63() int64 {
+	s.draws += 1
+	return s.src.Int63()
+}
+
+func (s *countingSource) Uint64() uint64 {
+	s.draws += 1
+	return s.src.Uint64()
+}
+
+func (s *countingSource) Seed(seed int64) {
+	s.src.Seed(seed)
+	s.draws = 0
+}
+
+func (g *GameState) Snapshot() *Snapshot {
+	s := &Snapshot{
+		Version:   SnapshotVersion,
+		Seed:      g.seed,
+		RandDraws: g.source.draws,
+		NextId:    g.currentId,
+		Timestamp: g.timestamp,
+		Damage:    g.damageRule,
+		Players:   []PlayerSnapshot{},
+		Stack:     []AbilitySnapshot{},
+	}
+	if g.turn != nil {
+		s.Turn = &TurnSnapshot{
+			Number:        g.turn.turn,
+			Player:        g.turn.player.Id,
+			SourcesPlayed: g.turn.sourcesPlayed,
+		}
+		if g.turn.phase != nil {
+			s.Turn.Phase = g.turn.phase.phase
+			s.Turn.Priority = g.turn.phase.priority.Id
+		}
+	}
+	for _, p := range g.Players {
+		ps := PlayerSnapshot{
+			Id:         p.Id,
+			Nr:         p.nr,
+			Life:       p.life,
+			TurnsAfter: p.turnsAfter,
+			Lost:       p.lost,
+			Losing:     p.losing,
+			Loss:       p.loss,
+			Essence:    append([]string{}, p.essence...),
+			Decklist:   []CardID{},
+			Deck:       snapshotCards(p.deck.Cards),
+			Hand:       snapshotCards(p.hand.Cards),
+			Pile:       snapshotCards(p.pile.Cards),
+			Board:      []*CardSnapshot{},
+		}
+		for _, card := range p.decklist {
+			ps.Decklist = append(ps.Decklist, card.ID)
+		}
+		for _, card := range p.board.Slots {
+			if card == nil {
+				ps.Board = append(ps.Board, nil)
+			} else {
+				cs := snapshotCard(card)
+				ps.Board = append(ps.Board, &cs)
+			}
+		}
+		s.Players = append(s.Players, ps)
+	}
+	for _, a := range g.stack.cards {
+		s.Stack = append(s.Stack, snapshotAbility(a))
+	}
+	return s
+}
+
+func snapshotCards(cards []*CardInstance) []CardSnapshot {
+	snaps := []CardSnapshot{}
+	for _, card := range cards {
+		snaps = append(snaps, snapshotCard(card))
+	}
+	return snaps
+}
+
+func snapshotCard(c *CardInstance) CardSnapshot {
+	cs := CardSnapshot{
+		Id:         c.ID,
+		Card:       c.Card.ID,
+		Owner:      c.Owner.Id,
+		Controller: c.Controller.Id,
+		Activated:  c.activated,
+		Attacking:  c.attacking,
+		Flipped:    c.flipped,
+		Destroyed:  c.destroyed,
+		Timestamp:  c.timestamp,
+		Damage:     c.damage,
+		Prevent:    c.prevent,
+		Counters:   maps.Clone(c.counters),
+		Modifiers:  append([]Mods{}, c.modifier...),
+	}
+	if c.stats != nil {
+		stats := *c.stats
+		cs.Stats = &stats
+	}
+	if c.HasType("token") {
+		cs.Token = &TokenSnapshot{Types: []string{}, Subtypes: []string{}, Stats: c.Card.Stats}
+		for _, t := range c.Card.Types {
+			cs.Token.Types = append(cs.Token.Types, string(t.Value))
+		}
+		for _, t := range c.Card.Subtypes {
+			cs.Token.Subtypes = append(cs.Token.Subtypes, t.Value)
+		}
+	}
+	return cs
+}
+
+func snapshotAbility(a *AbilityInstance) AbilitySnapshot {
+	as := AbilitySnapshot{
+		Id:         a.id,
+		Kind:       "cast",
+		Index:      -1,
+		Source:     a.Source.ID,
+		Controller: a.Controller.Id,
+		Field:      a.Field,
+		X:          a.X,
+		Modes:      a.Modes,
+		Event:      a.Event,
+		Copy:       a.Copy,
+		This:       objectIds(a.This),
+		Sacrificed: objectIds(a.Sacrificed),
+		Targeting:  objectIds(a.Targeting),
+	}
+	switch ab := a.Ability.(type) {
+	case *Activated:
+		as.Kind = "activated"
+		for i, other := range a.Source.GetActivatedAbilities() {
+			if other == ab || reflect.DeepEqual(other, ab) {
+				as.Index = i
+				break
+			}
+		}
+	case Triggered:
+		as.Kind = "triggered"
+		for i, other := range a.Source.GetTriggeredAbilities() {
+			if reflect.DeepEqual(*other, ab) {
+				as.Index = i
+				break
+			}
+		}
+	default:
+		if !a.Copy {
+			// Copies share the card of the spell they copy
+			card := snapshotCard(a.Source)
+			as.Card = &card
+		}
+	}
+	for _, e := range a.Effects {
+		as.Effects = append(as.Effects, EffectSnapshot{
+			Subjects: objectIds(e.Subjects),
+			Matches:  objectIds(e.matches),
+		})
+	}
+	return as
+}
+
+func objectIds(objects []any) []int {
+	ids := []int{}
+	for _, o := range objects {
+		ids = append(ids, o.(GameObject).GetId())
+	}
+	return ids
+}
+
+// Restore creates a new game from a snapshot. Event handlers are not part
+// of a snapshot and have to be registered again.
+func Restore(s *Snapshot, lookup func(CardID) *Card) (*GameState, error) {
+	if s.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+	g := NewGame(s.Seed)
+	g.source = newCountingSource(s.Seed, s.RandDraws)
+	g.rng = rand.New(g.source)
+	r := &restorer{g, lookup, map[int]any{}, []func() error{}}
+	for _, ps := range s.Players {
+		p := &Player{
+			Id:         ps.Id,
+			game:       g,
+			nr:         ps.Nr,
+			life:       ps.Life,
+			turnsAfter: ps.TurnsAfter,
+			lost:       ps.Lost,
+			losing:     ps.Losing,
+			loss:       ps.Loss,
+			deck:       Pile{Cards: []*CardInstance{}},
+			hand:       Pile{Cards: []*CardInstance{}},
+			pile:       Pile{Cards: []*CardInstance{}},
+			board:      Board{Slots: make([]*CardInstance, boardSize)},
+			essence:    append([]string{}, ps.Essence...),
+			decklist:   []*Card{},
+			decider:    ChannelDecider{},
+			msgChan:    make(chan Msg, 1),
+		}
+		for _, id := range ps.Decklist {
+			card := lookup(id)
+			if card == nil {
+				return nil, fmt.Errorf("unknown card %d in decklist", id)
+			}
+			p.decklist = append(p.decklist, card)
+		}
+		g.Players = append(g.Players, p)
+		r.objects[p.Id] = p
+	}
+	for i, ps := range s.Players {
+		p := g.Players[i]
+		zones := []struct {
+			pile  *Pile
+			zone  Zone
+			cards []CardSnapshot
+		}{
+			{&p.deck, ZoneDeck, ps.Deck},
+			{&p.hand, ZoneHand, ps.Hand},
+			{&p.pile, ZonePile, ps.Pile},
+		}
+		for _, z := range zones {
+			for j, cs := range z.cards {
+				card, err := r.card(cs, z.zone, j)
+				if err != nil {
+					return nil, err
+				}
+				z.pile.Add(card)
+			}
+		}
+		for j, cs := range ps.Board {
+			if cs == nil || j >= boardSize {
+				continue
+			}
+			card, err := r.card(*cs, ZoneBoard, j)
+			if err != nil {
+				return nil, err
+			}
+			p.board.Slots[j] = card
+		}
+	}
+	for _, as := range s.Stack {
+		a, err := r.ability(as)
+		if err != nil {
+			return nil, err
+		}
+		g.stack.Add(a)
+	}
+	for _, resolve := range r.pending {
+		if err := resolve(); err != nil {
+			return nil, err
+		}
+	}
+	g.currentId = s.NextId
+	g.timestamp = s.Timestamp
+	g.damageRule = s.Damage
+	if s.Turn != nil {
+		player, ok := r.objects[s.Turn.Player].(*Player)
+		if !ok {
+			return nil, fmt.Errorf("unknown active player %d", s.Turn.Player)
+		}
+		g.turn = &Turn{g, player, nil, s.Turn.Number, s.Turn.SourcesPlayed}
+		if priority, ok := r.objects[s.Turn.Priority].(*Player); ok {
+			g.turn.phase = &Phase{g.turn, priority, s.Turn.Phase}
+		}
+	}
+	return g, nil
+}
+
+type restorer struct {
+	game    *GameState
+	lookup  func(CardID) *Card
+	objects map[int]any
+	pending []func() error
+}
+
+func (r *restorer) card(cs CardSnapshot, zone Zone, index int) (*CardInstance, error) {
+	var def *Card
+	if cs.Token != nil {
+		def = &Card{Stats: cs.Token.Stats}
+		for _, t := range cs.Token.Types {
+			def.Types = append(def.Types, CardType{TypeName(t)})
+		}
+		for _, t := range cs.Token.Subtypes {
+			def.Subtypes = append(def.Subtypes, SubType{t})
+		}
+	} else if def = r.lookup(cs.Card); def == nil {
+		return nil, fmt.Errorf("unknown card %d", cs.Card)
+	}
+	owner, ok := r.objects[cs.Owner].(*Player)
+	if !ok {
+		return nil, fmt.Errorf("unknown owner %d of card %d", cs.Owner, cs.Id)
+	}
+	controller, ok := r.objects[cs.Controller].(*Player)
+	if !ok {
+		return nil, fmt.Errorf("unknown controller %d of card %d", cs.Controller, cs.Id)
+	}
+	c := &CardInstance{
+		ID:         cs.Id,
+		Card:       def,
+		activated:  cs.Activated,
+		attacking:  cs.Attacking,
+		flipped:    cs.Flipped,
+		destroyed:  cs.Destroyed,
+		timestamp:  cs.Timestamp,
+		damage:     cs.Damage,
+		prevent:    cs.Prevent,
+		counters:   maps.Clone(cs.Counters),
+		zone:       zone,
+		index:      index,
+		Owner:      owner,
+		Controller: controller,
+		modifier:   append([]Mods{}, cs.Modifiers...),
+	}
+	if cs.Stats != nil {
+		stats := *cs.Stats
+		c.stats = &stats
+	}
+	r.objects[c.ID] = c
+	return c, nil
+}
+
+func (r *restorer) ability(as AbilitySnapshot) (*AbilityInstance, error) {
+	controller, ok := r.objects[as.Controller].(*Player)
+	if !ok {
+		return nil, fmt.Errorf("unknown controller %d of stack item", as.Controller)
+	}
+	var source *CardInstance
+	if as.Card != nil {
+		card, err := r.card(*as.Card, ZoneStack, -1)
+		if err != nil {
+			return nil, err
+		}
+		source = card
+	} else if source, ok = r.objects[as.Source].(*CardInstance); !ok {
+		return nil, fmt.Errorf("unknown source %d of stack item", as.Source)
+	}
+	a := &AbilityInstance{
+		Source:     source,
+		Controller: controller,
+		Effects:    []EffectInstance{},
+		This:       []any{},
+		Sacrificed: []any{},
+		Targeting:  []any{},
+		Field:      as.Field,
+		X:          as.X,
+		Modes:      append([]int{}, as.Modes...),
+		Event:      as.Event,
+		Copy:       as.Copy,
+		id:         as.Id,
+	}
+	if as.Id != 0 {
+		r.objects[as.Id] = a
+	}
+	switch as.Kind {
+	case "activated":
+		abilities := source.GetActivatedAbilities()
+		if as.Index < 0 || as.Index >= len(abilities) {
+			return nil, fmt.Errorf("unknown activated ability %d of card %d", as.Index, source.ID)
+		}
+		a.Ability = abilities[as.Index]
+		abilities[as.Index].Effect.Do(controller, a)
+	case "triggered":
+		abilities := source.GetTriggeredAbilities()
+		if as.Index < 0 || as.Index >= len(abilities) {
+			return nil, fmt.Errorf("unknown triggered ability %d of card %d", as.Index, source.ID)
+		}
+		a.Ability = *abilities[as.Index]
+		abilities[as.Index].Effect.Do(controller, a)
+	default:
+		if source.IsSpell() {
+			source.spell(a)
+		}
+	}
+	// Objects can refer to cards restored later, so references are resolved last.
+	r.pending = append(r.pending, func() error {
+		var err error
+		if a.This, err = r.resolve(as.This); err != nil {
+			return err
+		}
+		if a.Sacrificed, err = r.resolve(as.Sacrificed); err != nil {
+			return err
+		}
+		if a.Targeting, err = r.resolve(as.Targeting); err != nil {
+			return err
+		}
+		for i := range a.Effects {
+			if i >= len(as.Effects) {
+				break
+			}
+			if a.Effects[i].Subjects, err = r.resolve(as.Effects[i].Subjects); err != nil {
+				return err
+			}
+			if a.Effects[i].matches, err = r.resolve(as.Effects[i].Matches); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return a, nil
+}
+
+func (r *restorer) resolve(ids []int) ([]any, error) {
+	objects := []any{}
+	for _, id := range ids {
+		o, ok := r.objects[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown object %d", id)
+		}
+		objects = append(objects, o)
+	}
+	return objects, nil
+}
+
+func (s *Snapshot) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+func ReadSnapshot(rd io.Reader) (*Snapshot, error) {
+	s := &Snapshot{}
+	if err := json.NewDecoder(rd).Decode(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
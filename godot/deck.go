@@ -24,6 +24,7 @@ func loadDeck() ([]*engine.Card, error) {
 		if err != nil {
 			continue
 		}
+		card.ID = engine.CardID(len(deck))
 		deck = append(deck, card)
 	}
 	if len(deck) == 0 {
@@ -1,14 +1,14 @@
 package godot
 
 import (
+	"context"
 	"fmt"
-	"math/rand"
 	"time"
 
 	"github.com/SvenDH/go-card-engine/engine"
 )
 
-func (c *CardGameUI) startGameLoop() {
+func (c *CardGameUI) startGameLoop(ctx context.Context) {
 	deck, err := loadDeck()
 	if err != nil {
 		c.queue(func() {
@@ -18,11 +18,10 @@ func (c *CardGameUI) startGameLoop() {
 		return
 	}
 
-	rand.Seed(time.Now().UnixNano())
-
-	game := engine.NewGame()
+	game := engine.NewGame(time.Now().UnixNano())
 	player := game.AddPlayer(deck...)
 	enemy := game.AddPlayer(deck...)
+	enemy.SetDecider(botDecider{})
 
 	c.game = game
 	c.player = player
@@ -34,10 +33,14 @@ func (c *CardGameUI) startGameLoop() {
 	})
 
 	game.On(engine.AllEvents, c.handleEngineEvent)
-	game.Run()
+	result := game.RunContext(ctx)
 
 	c.queue(func() {
-		c.logf("Game ended")
+		if result.Winner != nil {
+			c.logf("Game ended: %s wins (%s)", c.playerName(result.Winner), result.Reason)
+		} else {
+			c.logf("Game ended: %s", result.Reason)
+		}
 	})
 }
 
@@ -110,5 +113,23 @@ func (c *CardGameUI) applyEvent(event *engine.Event) {
 		c.showPrompt(event.Event, event.Player, event.Args)
 	case engine.EventPromptDiscard:
 		c.showPrompt(event.Event, event.Player, event.Args)
+	case engine.EventPromptBlock:
+		c.showPrompt(event.Event, event.Player, event.Args)
+	case engine.EventPromptLook:
+		c.showPrompt(event.Event, event.Player, event.Args)
+	case engine.EventPromptOrder:
+		c.showPrompt(event.Event, event.Player, event.Args)
+	case engine.EventPromptConfirm:
+		c.showPrompt(event.Event, event.Player, event.Args)
+	case engine.EventPromptMode:
+		c.showPrompt(event.Event, event.Player, event.Args)
+	case engine.EventPromptX:
+		c.showPrompt(event.Event, event.Player, event.Args)
+	case engine.EventPromptPay:
+		c.showPrompt(event.Event, event.Player, event.Args)
+	case engine.EventPromptUnless:
+		c.showPrompt(event.Event, event.Player, event.Args)
+	case engine.EventPromptSacrifice:
+		c.showPrompt(event.Event, event.Player, event.Args)
 	}
 }
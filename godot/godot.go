@@ -1,6 +1,8 @@
 package godot
 
 import (
+	"context"
+
 	"github.com/SvenDH/go-card-engine/engine"
 
 	"graphics.gd/classdb"
@@ -45,6 +47,7 @@ type CardGameUI struct {
 	board3d *board3DScene
 
 	eventQueue chan func()
+	cancel     context.CancelFunc
 
 	game   *engine.GameState
 	player *engine.Player
@@ -141,7 +144,16 @@ func (c *CardGameUI) Ready() {
 	}
 	c.hand = newHandScene(c)
 
-	go c.startGameLoop()
+	var ctx context.Context
+	ctx, c.cancel = context.WithCancel(context.Background())
+	go c.startGameLoop(ctx)
+}
+
+// ExitTree stops the engine loop when the UI is removed.
+func (c *CardGameUI) ExitTree() {
+	if c.cancel != nil {
+		c.cancel()
+	}
 }
 
 // Process pumps any pending game events onto the Godot thread each frame.
@@ -8,8 +8,7 @@ import (
 
 func (c *CardGameUI) showPrompt(kind engine.EventType, player *engine.Player, args []any) {
 	if player != c.player {
-		// Bot answers for the enemy.
-		c.botRespond(kind, args)
+		// The enemy's bot decider answers its own prompts.
 		return
 	}
 
@@ -39,6 +38,14 @@ func (c *CardGameUI) selectCardView(view *cardView) bool {
 	}
 	for i, choice := range c.promptChoices {
 		card, ok := choice.(*engine.CardInstance)
+		switch v := choice.(type) {
+		case engine.Block:
+			// Blocks are chosen by selecting the blocking unit.
+			card, ok = v.Blocker, true
+		case *engine.AbilityInstance:
+			// Stack items are chosen by selecting their card.
+			card, ok = v.Source, true
+		}
 		if !ok || card == nil {
 			continue
 		}
@@ -64,45 +71,83 @@ func (c *CardGameUI) clearPrompt() {
 	c.promptExpected = 0
 }
 
-func (c *CardGameUI) botRespond(kind engine.EventType, args []any) {
-	switch kind {
-	case engine.EventPromptCard:
-		choices := args[1:]
-		if len(choices) == 0 {
-			c.enemy.Send(engine.Msg{Selected: []int{engine.SkipCode}})
-			return
-		}
-		idx := rand.Intn(len(choices))
-		c.enemy.Send(engine.Msg{Selected: []int{idx}})
-	case engine.EventPromptField:
-		choices := args[1:]
-		if len(choices) == 0 {
-			c.enemy.Send(engine.Msg{Selected: []int{engine.SkipCode}})
-			return
-		}
-		c.enemy.Send(engine.Msg{Selected: []int{rand.Intn(len(choices))}})
-	case engine.EventPromptAbility:
-		c.enemy.Send(engine.Msg{Selected: []int{0}})
-	case engine.EventPromptTarget:
-		choices := args[1:]
-		if len(choices) == 0 {
-			c.enemy.Send(engine.Msg{Selected: []int{engine.SkipCode}})
-			return
-		}
-		c.enemy.Send(engine.Msg{Selected: []int{rand.Intn(len(choices))}})
-	case engine.EventPromptSource:
-		// 80% chance to use as source.
-		if rand.Float32() < 0.8 {
-			c.enemy.Send(engine.Msg{Selected: []int{1}})
-		} else {
-			c.enemy.Send(engine.Msg{Selected: []int{0}})
-		}
-	case engine.EventPromptDiscard:
-		choices := args[1:]
-		if len(choices) == 0 {
-			c.enemy.Send(engine.Msg{Selected: []int{engine.SkipCode}})
-			return
-		}
-		c.enemy.Send(engine.Msg{Selected: []int{rand.Intn(len(choices))}})
+// botDecider answers the enemy's prompts directly on the game goroutine.
+type botDecider struct{}
+
+func (botDecider) pick(choices []any) (int, error) {
+	if len(choices) == 0 {
+		return engine.SkipCode, nil
+	}
+	return rand.Intn(len(choices)), nil
+}
+
+func (b botDecider) ChooseCard(p *engine.Player, choices []any) (int, error) {
+	return b.pick(choices)
+}
+
+func (b botDecider) ChooseField(p *engine.Player, choices []any) (int, error) {
+	return b.pick(choices)
+}
+
+func (botDecider) ChooseAbility(p *engine.Player, choices []any) (int, error) {
+	return 0, nil
+}
+
+func (b botDecider) ChooseTarget(p *engine.Player, choices []any) (int, error) {
+	return b.pick(choices)
+}
+
+func (botDecider) ChooseSource(p *engine.Player) (int, error) {
+	// 80% chance to use as source.
+	if rand.Float32() < 0.8 {
+		return 1, nil
 	}
+	return 0, nil
+}
+
+func (b botDecider) ChooseBlock(p *engine.Player, choices []any) ([]int, error) {
+	i, err := b.pick(choices)
+	return []int{i}, err
+}
+
+func (b botDecider) ChooseDiscard(p *engine.Player, n int, choices []any) ([]int, error) {
+	i, err := b.pick(choices)
+	return []int{i}, err
+}
+
+func (b botDecider) ChooseSacrifice(p *engine.Player, n int, choices []any) ([]int, error) {
+	i, err := b.pick(choices)
+	return []int{i}, err
+}
+
+func (botDecider) Confirm(p *engine.Player, card *engine.CardInstance) (bool, error) {
+	return rand.Intn(2) == 0, nil
+}
+
+func (botDecider) ConfirmPay(p *engine.Player, card *engine.CardInstance, costs []engine.CostType) (bool, error) {
+	return rand.Intn(2) == 0, nil
+}
+
+func (botDecider) ChooseModes(p *engine.Player, n int, modes []any) ([]int, error) {
+	return rand.Perm(len(modes))[:min(n, len(modes))], nil
+}
+
+func (botDecider) ChooseX(p *engine.Player, choices []any) (int, error) {
+	if len(choices) == 0 {
+		return engine.SkipCode, nil
+	}
+	// Spend as much as possible on X.
+	return len(choices) - 1, nil
+}
+
+func (b botDecider) ChooseEssence(p *engine.Player, choices []any) (int, error) {
+	return b.pick(choices)
+}
+
+func (botDecider) Look(p *engine.Player, cards []any) error {
+	return nil
+}
+
+func (botDecider) ChooseOrder(p *engine.Player, cards []any) ([]int, error) {
+	return rand.Perm(len(cards)), nil
 }
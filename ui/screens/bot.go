@@ -7,111 +7,182 @@ import (
 	"github.com/SvenDH/go-card-engine/engine"
 )
 
-// enemyBotPromptCard handles the bot's card selection logic
-func (e *CardGame) enemyBotPromptCard(choices []any, player *engine.Player) {
+// enemyBot makes the enemy's decisions, the engine calls it on the game goroutine
+type enemyBot struct {
+	game *CardGame
+}
+
+// ChooseCard handles the bot's card selection logic
+func (b *enemyBot) ChooseCard(player *engine.Player, choices []any) (int, error) {
+	e := b.game
 	// Add a small delay to make the bot feel more natural
-	go func() {
-		time.Sleep(300 * time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
 
-		if len(choices) == 0 {
-			e.enemy.Send(engine.Msg{Selected: []int{engine.SkipCode}})
-			return
-		}
+	if len(choices) == 0 {
+		return engine.SkipCode, nil
+	}
 
-		// 70% chance to play a card, 30% chance to skip
-		if rand.Float32() < 0.7 {
-			// Select a random card from available choices
-			selected := rand.Intn(len(choices))
-			// Track the enemy's selected card
-			if cardInst, ok := choices[selected].(*engine.CardInstance); ok {
-				if card, exists := e.cardMap[cardInst.GetId()]; exists {
-					e.enemySelectedCard = card
-				} else {
-					// Create the card if it doesn't exist yet
-					e.enemySelectedCard = e.CreateCard(cardInst)
-				}
+	// 70% chance to play a card, 30% chance to skip
+	if rand.Float32() < 0.7 {
+		// Select a random card from available choices
+		selected := rand.Intn(len(choices))
+		// Track the enemy's selected card
+		if cardInst, ok := choices[selected].(*engine.CardInstance); ok {
+			if card, exists := e.cardMap[cardInst.GetId()]; exists {
+				e.enemySelectedCard = card
+			} else {
+				// Create the card if it doesn't exist yet
+				e.enemySelectedCard = e.CreateCard(cardInst)
 			}
-			e.enemy.Send(engine.Msg{Selected: []int{selected}})
-		} else {
-			e.enemySelectedCard = nil
-			e.enemy.Send(engine.Msg{Selected: []int{engine.SkipCode}})
 		}
-	}()
+		return selected, nil
+	}
+	e.enemySelectedCard = nil
+	return engine.SkipCode, nil
 }
 
-// enemyBotPromptField handles the bot's field selection logic
-func (e *CardGame) enemyBotPromptField(choices []any) {
-	go func() {
-		time.Sleep(200 * time.Millisecond)
+// ChooseField handles the bot's field selection logic
+func (b *enemyBot) ChooseField(player *engine.Player, choices []any) (int, error) {
+	time.Sleep(200 * time.Millisecond)
 
-		if len(choices) == 0 {
-			e.enemy.Send(engine.Msg{Selected: []int{engine.SkipCode}})
-			return
-		}
+	if len(choices) == 0 {
+		return engine.SkipCode, nil
+	}
 
-		// Select a random field from available choices
-		selected := rand.Intn(len(choices))
-		e.enemy.Send(engine.Msg{Selected: []int{selected}})
-	}()
+	// Select a random field from available choices
+	return rand.Intn(len(choices)), nil
 }
 
-// enemyBotPromptAbility handles the bot's ability selection logic
-func (e *CardGame) enemyBotPromptAbility(choices []any) {
-	go func() {
-		time.Sleep(250 * time.Millisecond)
+// ChooseAbility handles the bot's ability selection logic
+func (b *enemyBot) ChooseAbility(player *engine.Player, choices []any) (int, error) {
+	time.Sleep(250 * time.Millisecond)
 
-		if len(choices) == 0 {
-			e.enemy.Send(engine.Msg{Selected: []int{engine.SkipCode}})
-			return
-		}
+	if len(choices) == 0 {
+		return engine.SkipCode, nil
+	}
 
-		// Always activate first available ability
-		e.enemy.Send(engine.Msg{Selected: []int{0}})
-	}()
+	// Always activate first available ability
+	return 0, nil
 }
 
-// enemyBotPromptTarget handles the bot's target selection logic
-func (e *CardGame) enemyBotPromptTarget(choices []any) {
-	go func() {
-		time.Sleep(200 * time.Millisecond)
+// ChooseTarget handles the bot's target selection logic
+func (b *enemyBot) ChooseTarget(player *engine.Player, choices []any) (int, error) {
+	time.Sleep(200 * time.Millisecond)
 
-		if len(choices) == 0 {
-			e.enemy.Send(engine.Msg{Selected: []int{engine.SkipCode}})
-			return
-		}
+	if len(choices) == 0 {
+		return engine.SkipCode, nil
+	}
 
-		// Select a random target
-		selected := rand.Intn(len(choices))
-		e.enemy.Send(engine.Msg{Selected: []int{selected}})
-	}()
+	// Select a random target
+	return rand.Intn(len(choices)), nil
 }
 
-// enemyBotPromptSource handles the bot's source/spell selection logic
-func (e *CardGame) enemyBotPromptSource(choices []any) {
-	go func() {
-		time.Sleep(150 * time.Millisecond)
+// ChooseSource handles the bot's source/spell selection logic
+func (b *enemyBot) ChooseSource(player *engine.Player) (int, error) {
+	time.Sleep(150 * time.Millisecond)
 
-		// 80% chance to play as source (option 1), 20% as spell (option 0)
-		if rand.Float32() < 0.8 {
-			e.enemy.Send(engine.Msg{Selected: []int{1}})
-		} else {
-			e.enemy.Send(engine.Msg{Selected: []int{0}})
-		}
-	}()
+	// 80% chance to play as source (option 1), 20% as spell (option 0)
+	if rand.Float32() < 0.8 {
+		return 1, nil
+	}
+	return 0, nil
 }
 
-// enemyBotPromptDiscard handles the bot's discard selection logic
-func (e *CardGame) enemyBotPromptDiscard(choices []any) {
-	go func() {
-		time.Sleep(200 * time.Millisecond)
+// ChooseBlock handles the bot's blocking logic
+func (b *enemyBot) ChooseBlock(player *engine.Player, choices []any) ([]int, error) {
+	time.Sleep(200 * time.Millisecond)
 
-		if len(choices) == 0 {
-			e.enemy.Send(engine.Msg{Selected: []int{engine.SkipCode}})
-			return
+	// 50% chance to let each attack through, the engine skips blocks with
+	// units that are already blocking
+	selected := []int{}
+	for i := range choices {
+		if rand.Float32() >= 0.5 {
+			selected = append(selected, i)
 		}
+	}
+	return selected, nil
+}
 
-		// Discard a random card
-		selected := rand.Intn(len(choices))
-		e.enemy.Send(engine.Msg{Selected: []int{selected}})
-	}()
+// Confirm handles the bot's choice to do optional effects
+func (b *enemyBot) Confirm(player *engine.Player, card *engine.CardInstance) (bool, error) {
+	time.Sleep(150 * time.Millisecond)
+
+	// Optional effects are mostly good for the bot
+	return rand.Float32() < 0.8, nil
+}
+
+// ConfirmPay handles the bot's choice to pay to stop an effect
+func (b *enemyBot) ConfirmPay(player *engine.Player, card *engine.CardInstance, costs []engine.CostType) (bool, error) {
+	time.Sleep(150 * time.Millisecond)
+
+	// Effects the opponent makes the bot pay for are mostly bad for it
+	return rand.Float32() < 0.8, nil
+}
+
+// ChooseModes handles the bot's mode selection logic
+func (b *enemyBot) ChooseModes(player *engine.Player, n int, modes []any) ([]int, error) {
+	time.Sleep(200 * time.Millisecond)
+
+	// Choose random modes
+	return rand.Perm(len(modes))[:min(n, len(modes))], nil
+}
+
+// ChooseX handles the bot's choice of X in a cost
+func (b *enemyBot) ChooseX(player *engine.Player, choices []any) (int, error) {
+	time.Sleep(150 * time.Millisecond)
+
+	if len(choices) == 0 {
+		return engine.SkipCode, nil
+	}
+	// Spend all available essence on X
+	return len(choices) - 1, nil
+}
+
+// ChooseEssence handles the bot's choice of essence to pay with
+func (b *enemyBot) ChooseEssence(player *engine.Player, choices []any) (int, error) {
+	time.Sleep(150 * time.Millisecond)
+
+	if len(choices) == 0 {
+		return engine.SkipCode, nil
+	}
+	// Pay with a random essence
+	return rand.Intn(len(choices)), nil
+}
+
+// Look handles the bot looking at hidden cards
+func (b *enemyBot) Look(player *engine.Player, cards []any) error {
+	time.Sleep(200 * time.Millisecond)
+	return nil
+}
+
+// ChooseOrder handles the bot's card ordering logic
+func (b *enemyBot) ChooseOrder(player *engine.Player, cards []any) ([]int, error) {
+	time.Sleep(200 * time.Millisecond)
+
+	// Put the cards in a random order
+	return rand.Perm(len(cards)), nil
+}
+
+// ChooseDiscard handles the bot's discard selection logic
+func (b *enemyBot) ChooseDiscard(player *engine.Player, n int, choices []any) ([]int, error) {
+	time.Sleep(200 * time.Millisecond)
+
+	if len(choices) == 0 {
+		return []int{engine.SkipCode}, nil
+	}
+
+	// Discard a random card
+	return []int{rand.Intn(len(choices))}, nil
+}
+
+// ChooseSacrifice handles the bot's choice of what to sacrifice for a cost
+func (b *enemyBot) ChooseSacrifice(player *engine.Player, n int, choices []any) ([]int, error) {
+	time.Sleep(200 * time.Millisecond)
+
+	if len(choices) == 0 {
+		return []int{engine.SkipCode}, nil
+	}
+
+	// Sacrifice random objects
+	return rand.Perm(len(choices))[:min(n, len(choices))], nil
 }
@@ -76,7 +76,16 @@ func (c *Card) Init() ui.Cmd {
 			if c.game != nil && c.game.promptingTarget && c.cardInstance != nil {
 				// Check if this card is a valid target
 				for i, choice := range c.game.targetChoices {
-					if cardInst, ok := choice.(*engine.CardInstance); ok {
+					cardInst, ok := choice.(*engine.CardInstance)
+					switch v := choice.(type) {
+					case engine.Block:
+						// Blocks are chosen by clicking the blocking unit
+						cardInst, ok = v.Blocker, true
+					case *engine.AbilityInstance:
+						// Stack items are chosen by clicking their card
+						cardInst, ok = v.Source, true
+					}
+					if ok {
 						if cardInst.GetId() == c.cardInstance.GetId() {
 							// Valid target selected - send to game
 							c.game.player.Send(engine.Msg{Selected: []int{i}})
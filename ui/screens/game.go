@@ -1,9 +1,10 @@
 package screens
 
 import (
+	"context"
 	"fmt"
-	"math/rand"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -32,6 +33,7 @@ func LoadCards() {
 			continue
 		}
 		fmt.Println(card)
+		card.ID = engine.CardID(len(cards))
 		cards = append(cards, card)
 	}
 }
@@ -60,6 +62,8 @@ type CardGame struct {
 	abilityMenuHovered []bool
 	abilityChoices     []any
 	abilityCard        *Card
+	abilityPicks       int   // Number of menu choices to collect, like modes
+	abilityPicked      []int // Menu choices collected so far
 	promptingAbility   bool
 	targetChoices      []any   // Available targets for selection
 	targetableCards    []*Card // Cards that can be targeted
@@ -98,6 +102,7 @@ type CardGame struct {
 	player    *engine.Player
 	enemy     *engine.Player
 	cardMap   map[int]*Card
+	cancel    context.CancelFunc // Stops the game loop when the screen closes
 
 	// Mutex to protect shared state from concurrent access
 	mu sync.RWMutex
@@ -152,6 +157,8 @@ func NewCardGame(width, height int) *CardGame {
 				e.abilityMenuHovered = []bool{}
 				e.abilityChoices = []any{}
 				e.abilityCard = nil
+				e.abilityPicks = 0
+				e.abilityPicked = nil
 				// Clear attack target preview
 				e.attackTargetField = -1
 				e.attackTargetIsPreview = false
@@ -190,25 +197,33 @@ func NewCardGame(width, height int) *CardGame {
 }
 
 func (e *CardGame) Init() ui.Cmd {
-	// Initialize random seed for bot
-	rand.Seed(time.Now().UnixNano())
-
 	e.playerLanes.Init()
 	e.enemyLanes.Init()
 
 	// Deck is no longer pre-created - cards are created on-demand when drawn
 
-	go e.StartGame()
+	var ctx context.Context
+	ctx, e.cancel = context.WithCancel(context.Background())
+	go e.StartGame(ctx)
 
 	return nil
 }
 
-func (e *CardGame) StartGame() {
-	e.gameState = engine.NewGame()
+func (e *CardGame) StartGame(ctx context.Context) {
+	e.gameState = engine.NewGame(time.Now().UnixNano())
 	e.player = e.gameState.AddPlayer(cards...)
 	e.enemy = e.gameState.AddPlayer(cards...)
+	e.enemy.SetDecider(&enemyBot{e})
 	e.gameState.On(engine.AllEvents, e.eventHandler)
-	e.gameState.Run()
+	e.gameState.RunContext(ctx)
+}
+
+// Close stops the game loop, call it when the screen is closed so the game
+// goroutine waiting for a prompt ends.
+func (e *CardGame) Close() {
+	if e.cancel != nil {
+		e.cancel()
+	}
 }
 
 func (e *CardGame) CreateCard(cardInstance *engine.CardInstance) *Card {
@@ -406,6 +421,10 @@ func (e *CardGame) PromptAbility(choices []any) {
 			},
 			Click: func(msg ui.Msg) ui.Cmd {
 				if e.promptingAbility && e.player != nil {
+					selected, done := e.pickAbility(choiceIndex)
+					if !done {
+						return nil
+					}
 					e.prompting = false
 					e.promptingAbility = false
 					e.abilityMenu = []*ui.Zone{}
@@ -416,7 +435,7 @@ func (e *CardGame) PromptAbility(choices []any) {
 					e.attackTargetField = -1
 					e.attackTargetIsPreview = false
 					e.enableHandCards()
-					e.player.Send(engine.Msg{Selected: []int{choiceIndex}})
+					e.player.Send(engine.Msg{Selected: selected})
 				}
 				return nil
 			},
@@ -424,6 +443,25 @@ func (e *CardGame) PromptAbility(choices []any) {
 	}
 }
 
+// pickAbility adds choice i to the picks of the ability menu and returns the
+// answer once enough choices are picked. Menus that ask for one choice answer
+// right away.
+func (e *CardGame) pickAbility(i int) ([]int, bool) {
+	if e.abilityPicks <= 1 {
+		return []int{i}, true
+	}
+	if !slices.Contains(e.abilityPicked, i) {
+		e.abilityPicked = append(e.abilityPicked, i)
+	}
+	if len(e.abilityPicked) < e.abilityPicks {
+		return nil, false
+	}
+	picked := e.abilityPicked
+	e.abilityPicks = 0
+	e.abilityPicked = nil
+	return picked, true
+}
+
 func (e *CardGame) PromptTarget(choices []any) {
 	e.targetChoices = choices
 	e.targetableCards = nil
@@ -437,6 +475,16 @@ func (e *CardGame) PromptTarget(choices []any) {
 			if card, exists := e.cardMap[v.GetId()]; exists {
 				e.targetableCards = append(e.targetableCards, card)
 			}
+		case engine.Block:
+			// Blockers are chosen by clicking the blocking unit
+			if card, exists := e.cardMap[v.Blocker.GetId()]; exists {
+				e.targetableCards = append(e.targetableCards, card)
+			}
+		case *engine.AbilityInstance:
+			// Spells and abilities on the stack are chosen by their card
+			if card, exists := e.cardMap[v.Source.GetId()]; exists {
+				e.targetableCards = append(e.targetableCards, card)
+			}
 		case *engine.Player:
 			// This is a player target - we'll use field index -1 for player
 			// Determine which player this is
@@ -754,8 +802,6 @@ func (e *CardGame) eventHandler(event *engine.Event) {
 		if player == e.player {
 			e.prompting = true
 			e.PromptCard(event.Args[1:])
-		} else {
-			e.enemyBotPromptCard(event.Args[1:], player)
 		}
 	case engine.EventPromptField:
 		if player == e.player {
@@ -766,15 +812,12 @@ func (e *CardGame) eventHandler(event *engine.Event) {
 				// Set card on stack (fieldIndex will be determined by bot)
 				e.stack.SetCard(e.enemySelectedCard, 0)
 			}
-			e.enemyBotPromptField(event.Args[1:])
 		}
 	case engine.EventPromptAbility:
 		if player == e.player {
 			e.prompting = true
 			e.promptingAbility = true
 			e.PromptAbility(event.Args[1:])
-		} else {
-			e.enemyBotPromptAbility(event.Args[1:])
 		}
 	case engine.EventPromptTarget:
 		if player == e.player {
@@ -782,24 +825,89 @@ func (e *CardGame) eventHandler(event *engine.Event) {
 			e.promptingTarget = true
 			e.enableHandCards()
 			e.PromptTarget(event.Args[1:])
-		} else {
-			e.enemyBotPromptTarget(event.Args[1:])
+		}
+	case engine.EventPromptBlock:
+		if player == e.player {
+			e.prompting = true
+			e.promptingTarget = true
+			e.PromptTarget(event.Args[1:])
+		}
+	case engine.EventPromptSacrifice:
+		if player == e.player {
+			// Pick one of the own units, skipping sacrifices the first ones
+			e.prompting = true
+			e.promptingTarget = true
+			e.PromptTarget(event.Args[1:])
 		}
 	case engine.EventPromptSource:
 		if player == e.player {
 			e.prompting = true
 			// Re-enable all cards when switching to source prompt
 			e.enableHandCards()
-		} else {
-			e.enemyBotPromptSource(event.Args[1:])
 		}
 	case engine.EventPromptDiscard:
 		if player == e.player {
 			e.prompting = true
 			// Re-enable all cards when switching to discard prompt
 			e.enableHandCards()
-		} else {
-			e.enemyBotPromptDiscard(event.Args[1:])
+		}
+	case engine.EventPromptConfirm:
+		if player == e.player {
+			// Choosing yes does the effect, skipping declines it
+			e.prompting = true
+			e.promptingAbility = true
+			e.PromptAbility([]any{"Yes"})
+		}
+	case engine.EventPromptUnless:
+		if player == e.player {
+			// Choosing to pay stops the effect, skipping lets it happen
+			e.prompting = true
+			e.promptingAbility = true
+			cost := ""
+			for _, c := range event.Args[2:] {
+				cost += fmt.Sprintf("%v", c)
+			}
+			e.PromptAbility([]any{"Pay " + cost})
+		}
+	case engine.EventPromptMode:
+		if player == e.player {
+			// Pick the modes from a menu, skipping takes the first modes
+			e.prompting = true
+			e.promptingAbility = true
+			modes := []any{}
+			for i, m := range event.Args[1:] {
+				text := m.(engine.Mode).Text()
+				if text == "" {
+					text = fmt.Sprintf("Mode %d", i+1)
+				}
+				modes = append(modes, text)
+			}
+			e.PromptAbility(modes)
+			e.abilityPicks = min(event.Args[0].(int), len(modes))
+			e.abilityPicked = nil
+		}
+	case engine.EventPromptX:
+		if player == e.player {
+			// Choose X from a menu, skipping makes X 0
+			e.prompting = true
+			e.promptingAbility = true
+			values := []any{}
+			for _, x := range event.Args[1:] {
+				values = append(values, fmt.Sprintf("X = %d", x))
+			}
+			e.PromptAbility(values)
+		}
+	case engine.EventPromptPay:
+		if player == e.player {
+			// Choose the essence to pay with, skipping pays with the first
+			e.prompting = true
+			e.promptingAbility = true
+			e.PromptAbility(event.Args[1:])
+		}
+	case engine.EventPromptLook, engine.EventPromptOrder:
+		if player == e.player {
+			// Passing ends looking and keeps the cards in their order
+			e.prompting = true
 		}
 	}
 }
@@ -1404,7 +1512,11 @@ func (e *CardGame) handleEnterKey() ui.Cmd {
 	if e.promptingAbility && len(e.abilityMenu) > 0 && e.focusMode == "ability-menu" {
 		// Select the focused ability
 		if e.focusAbilityIndex >= 0 && e.focusAbilityIndex < len(e.abilityChoices) {
-			e.player.Send(engine.Msg{Selected: []int{e.focusAbilityIndex}})
+			selected, done := e.pickAbility(e.focusAbilityIndex)
+			if !done {
+				return nil
+			}
+			e.player.Send(engine.Msg{Selected: selected})
 			e.promptingAbility = false
 			e.abilityMenu = []*ui.Zone{}
 			e.abilityMenuHovered = []bool{}
@@ -1630,6 +1742,8 @@ func (e *CardGame) handleSkipAction() ui.Cmd {
 	e.abilityMenuHovered = []bool{}
 	e.abilityChoices = []any{}
 	e.abilityCard = nil
+	e.abilityPicks = 0
+	e.abilityPicked = nil
 	e.targetChoices = nil
 	e.targetableCards = nil
 	e.targetableFields = nil
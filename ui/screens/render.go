@@ -2,6 +2,7 @@ package screens
 
 import (
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/SvenDH/go-card-engine/ui"
@@ -316,6 +317,8 @@ func (e *CardGame) renderAbilityMenu(screen *ui.Image) *ui.Image {
 			borderColor = ui.Colors["white"]
 		} else if e.abilityMenuHovered[i] {
 			borderColor = ui.Colors["brown"]
+		} else if slices.Contains(e.abilityPicked, i) {
+			borderColor = ui.Colors["light-beige"]
 		}
 		abilityText := fmt.Sprintf("%d. %s", i+1, e.abilityChoices[i])
 		// Extract ability text if it's from a card